@@ -0,0 +1,206 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchLoader loads several keys in one call, e.g. with a SQL IN (...) query.
+// Keys missing from the returned map fail with ErrNotFound.
+type BatchLoader[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// ErrNotFound is the error of a key that a BatchLoader did not return.
+var ErrNotFound = errors.New("tmc: key not found")
+
+// KeyErrors is returned by GetMany when some keys failed to load. It maps
+// every failed key to its error.
+type KeyErrors[K comparable] map[K]error
+
+func (e KeyErrors[K]) Error() string {
+	for key, err := range e {
+		if len(e) == 1 {
+			return fmt.Sprintf("tmc: loading %v: %v", key, err)
+		}
+		return fmt.Sprintf("tmc: loading %v: %v (and %d more errors)", key, err, len(e)-1)
+	}
+	return "tmc: no errors"
+}
+
+// waiter is a GetMany lookup waiting on an in-flight entry.
+type waiter[K comparable, V any] struct {
+	ctx   context.Context
+	start time.Time
+	key   K
+	s     *shard[K, V]
+	i     *item[V]
+	hit   bool
+}
+
+// GetMany returns the values of keys. Cached keys are answered from memory,
+// keys that are already loading are waited on, keys on disk are read back as
+// by Get, and the remaining keys are loaded with a single call of the
+// WithBatchLoader loader, or one loader call each if there is none. If some keys fail, the values of the others are
+// returned together with a KeyErrors.
+func (tmc *TMCache[K, V]) GetMany(keys []K, ttl time.Duration) (map[K]V, error) {
+	return tmc.GetManyContext(context.Background(), keys, ttl)
+}
+
+// GetManyContext is like GetMany but stops waiting when ctx is done, in
+// which case the values found so far are returned with ctx.Err().
+func (tmc *TMCache[K, V]) GetManyContext(ctx context.Context, keys []K, ttl time.Duration) (map[K]V, error) {
+	values := make(map[K]V, len(keys))
+	errs := make(KeyErrors[K])
+	seen := make(map[K]bool, len(keys))
+	var waits, misses []waiter[K, V]
+
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		w := waiter[K, V]{start: time.Now(), key: key, s: tmc.shard(key)}
+		w.ctx = tmc.observer.LookupStart(ctx, key)
+		w.s.mu.Lock()
+		i, r, err, created := tmc.lookup(w.s, key, ttl)
+		tmc.unlock(w.s)
+
+		if i == nil {
+			tmc.accessed(key)
+			if err != nil {
+				errs[key] = err
+			} else {
+				values[key] = r.Value
+			}
+			tmc.observer.LookupEnd(w.ctx, key, true, time.Since(w.start), err)
+			continue
+		}
+		w.i, w.hit = i, r.Hit
+		if created {
+			misses = append(misses, w)
+		} else {
+			tmc.observer.CoalescedWait(w.ctx, key)
+		}
+		waits = append(waits, w)
+	}
+
+	tmc.loadMany(ctx, misses)
+
+	for n, w := range waits {
+		select {
+		case <-w.i.done:
+			if err := w.i.res.err; err != nil {
+				errs[w.key] = err
+			} else {
+				values[w.key] = w.i.res.value
+			}
+			hit := w.hit || w.i.restored
+			tmc.observer.LookupEnd(w.ctx, w.key, hit, time.Since(w.start), w.i.res.err)
+		case <-ctx.Done():
+			for _, w := range waits[n:] {
+				tmc.abandon(w.s, w.key, w.i)
+				tmc.observer.LookupEnd(w.ctx, w.key, w.hit, time.Since(w.start), ctx.Err())
+			}
+			return values, ctx.Err()
+		}
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// loadMany starts loading the in-flight entries created by GetMany. A batch
+// load is cancelled only once the waiters of all its keys have given up.
+func (tmc *TMCache[K, V]) loadMany(ctx context.Context, misses []waiter[K, V]) {
+	if len(misses) == 0 {
+		return
+	}
+	if tmc.batch == nil {
+		for _, w := range misses {
+			lctx, cancel := context.WithCancel(detached{ctx})
+			w.s.mu.Lock()
+			w.i.cancel = cancel
+			w.s.mu.Unlock()
+			go tmc.load(lctx, w.s, w.key, w.i)
+		}
+		return
+	}
+
+	lctx, cancel := context.WithCancel(detached{ctx})
+	remaining := int64(len(misses))
+	for _, w := range misses {
+		var once sync.Once
+		w.s.mu.Lock()
+		w.i.cancel = func() {
+			once.Do(func() {
+				if atomic.AddInt64(&remaining, -1) == 0 {
+					cancel()
+				}
+			})
+		}
+		w.s.mu.Unlock()
+	}
+	go tmc.loadBatch(lctx, cancel, misses)
+}
+
+func (tmc *TMCache[K, V]) loadBatch(ctx context.Context, cancel context.CancelFunc, misses []waiter[K, V]) {
+	defer cancel()
+
+	// Keys on disk are read back as Get does, not loaded again.
+	remaining := misses[:0]
+	for _, w := range misses {
+		if !tmc.restore(w.s, w.key, w.i) {
+			remaining = append(remaining, w)
+		}
+	}
+	misses = remaining
+	if len(misses) == 0 {
+		return
+	}
+	tmc.stats.misses.Add(int64(len(misses)))
+
+	keys := make([]K, len(misses))
+	for n, w := range misses {
+		keys[n] = w.key
+	}
+	values, err := tmc.callBatch(ctx, keys)
+	for _, w := range misses {
+		value, ok := values[w.key]
+		kerr := err
+		if kerr == nil && !ok {
+			kerr = ErrNotFound
+		}
+		tmc.finish(w.s, w.key, w.i, value, EntryOptions{}, kerr)
+	}
+}
+
+// callBatch is call for the batch loader. Every key counts as a load in the
+// statistics and is reported to the Observer on its own.
+func (tmc *TMCache[K, V]) callBatch(ctx context.Context, keys []K) (values map[K]V, err error) {
+	start := time.Now()
+	octxs := make([]context.Context, len(keys))
+	for n, key := range keys {
+		octxs[n] = tmc.observer.LoadStart(ctx, key)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		d := time.Since(start)
+		for n, key := range keys {
+			kerr := err
+			if _, ok := values[key]; kerr == nil && !ok {
+				kerr = ErrNotFound
+			}
+			tmc.stats.loaded(d, kerr)
+			tmc.observer.LoadEnd(octxs[n], key, d, kerr)
+		}
+	}()
+	return tmc.batch(ctx, keys)
+}
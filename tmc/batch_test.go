@@ -0,0 +1,85 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetManyBatchesMisses(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	release := make(chan struct{})
+	cache := New(func(key string) (string, error) {
+		<-release
+		return "single " + key, nil
+	}, hour, WithBatchLoader(func(ctx context.Context, keys []string) (map[string]string, error) {
+		mu.Lock()
+		batches = append(batches, append([]string(nil), keys...))
+		mu.Unlock()
+		values := make(map[string]string)
+		for _, key := range keys {
+			if key != "missing" {
+				values[key] = "batch " + key
+			}
+		}
+		return values, nil
+	}))
+	defer cache.Close()
+
+	go cache.Get("inflight", hour)
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.GetMany([]string{"cached"}, hour)
+	go func() {
+		for cache.Stats().Coalesced == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+
+	values, err := cache.GetMany([]string{"cached", "inflight", "a", "b", "a", "missing"}, hour)
+
+	want := map[string]string{
+		"cached":   "batch cached",
+		"inflight": "single inflight",
+		"a":        "batch a",
+		"b":        "batch b",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("Error: values = %v; expected %v", values, want)
+	}
+	var kerrs KeyErrors[string]
+	if !errors.As(err, &kerrs) || len(kerrs) != 1 || kerrs["missing"] != ErrNotFound {
+		t.Errorf("Error: err = %v; expected ErrNotFound for 'missing'", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 {
+		t.Fatalf("Error: %d batch calls; expected 2", len(batches))
+	}
+	sort.Strings(batches[1])
+	if !reflect.DeepEqual(batches[1], []string{"a", "b", "missing"}) {
+		t.Errorf("Error: batch keys = %v; expected [a b missing]", batches[1])
+	}
+}
+
+func TestGetManyWithoutBatchLoader(t *testing.T) {
+	cache := New(func(key int) (int, error) {
+		return key * key, nil
+	}, hour)
+	defer cache.Close()
+
+	values, err := cache.GetMany([]int{1, 2, 3}, hour)
+	if err != nil {
+		t.Errorf("Error: err = %v; expected nil", err)
+	} else if !reflect.DeepEqual(values, map[int]int{1: 1, 2: 4, 3: 9}) {
+		t.Errorf("Error: values = %v; expected map[1:1 2:4 3:9]", values)
+	}
+}
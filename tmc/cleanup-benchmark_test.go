@@ -0,0 +1,50 @@
+package tmc
+
+import (
+	"testing"
+	"time"
+)
+
+const sweepEntries = 1000000
+
+// BenchmarkCleanup measures the pause of one cleanup tick over 1M entries of
+// which none are due, against the full sweep cleanup used to do.
+func BenchmarkCleanup(b *testing.B) {
+	cache := New(func(key int) (int, error) {
+		return key, nil
+	}, hour, WithShards[int, int](1))
+	defer cache.Close()
+	for key := 0; key < sweepEntries; key++ {
+		cache.Set(key, key, hour)
+	}
+
+	b.Run("heap", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			cache.routineCleanup()
+		}
+	})
+	b.Run("sweep", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			sweep(cache)
+		}
+	})
+}
+
+// sweep is the cleanup that visits every entry.
+func sweep[K comparable, V any](tmc *TMCache[K, V]) {
+	for _, s := range tmc.shards {
+		now := time.Now().UnixNano()
+		s.mu.Lock()
+		var expired []entry[K, V]
+		s.store.Range(func(k K, r Record[V]) bool {
+			if r.Expires.UnixNano() <= now {
+				expired = append(expired, entry[K, V]{key: k, record: r})
+			}
+			return true
+		})
+		for _, e := range expired {
+			tmc.remove(s, e.key, e.record, Expired)
+		}
+		tmc.unlock(s)
+	}
+}
@@ -0,0 +1,476 @@
+package tmc
+
+import (
+	"bytes"
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// disk is the tier WithDiskSpill keeps large and evicted values in. Its index
+// lives in memory; every value is a file in dir with a unique name, encoded
+// with the cache's codec. mu may be acquired while a shard lock is held,
+// never the other way around, so it only guards the index: files are
+// written, read and removed with no lock held.
+type disk[K comparable] struct {
+	dir       string
+	threshold int64
+	budget    int64
+
+	mu     sync.Mutex
+	files  map[K]*spillFile
+	lru    *list.List // keys of written files, most recent at the front
+	expiry expiry[K]
+	size   int64
+	trash  []string // paths of dropped files, removed by empty
+	dirty  atomic.Bool
+	writes sync.WaitGroup // reserved files not written yet
+}
+
+// spillFile is the index entry of a value on disk. Its path is empty while
+// the file is reserved but not written yet, and written is closed once it is
+// written or given up. The expiry of the value is kept here rather than in
+// the file, so reads of an idle value can slide it.
+type spillFile struct {
+	path      string
+	written   chan struct{}
+	size      int64
+	deadline  int64
+	expires   int64
+	refreshAt int64
+	idle      int64
+	limit     int64
+	large     bool
+	tags      []string
+	elem      *list.Element
+}
+
+// spilled is the content of a spill file.
+type spilled[V any] struct {
+	Value V
+}
+
+// spillWrite is a value to write to disk once the shard lock is released.
+// data holds the encoding of a large value; an evicted value is encoded
+// from o then.
+type spillWrite[K comparable, V any] struct {
+	key  K
+	file *spillFile
+	data []byte
+	o    outcome[V]
+}
+
+// spillPrefix starts the names of spill files, so files in dir that this
+// package did not create are left alone.
+const spillPrefix = "tmc-spill-"
+
+// swept holds the directories this process removed stale spill files from,
+// so a cache spilling to the directory of another one keeps its files.
+var swept sync.Map
+
+func newDisk[K comparable](dir string, threshold, budget int64) *disk[K] {
+	removeStale(dir)
+	return &disk[K]{
+		dir:       dir,
+		threshold: threshold,
+		budget:    budget,
+		files:     make(map[K]*spillFile),
+		lru:       list.New(),
+	}
+}
+
+// removeStale removes the spill files an earlier run left in dir, whose index was
+// lost with it, the first time this process uses dir.
+func removeStale(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	if _, done := swept.LoadOrStore(abs, true); done {
+		return
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), spillPrefix) && strings.HasSuffix(e.Name(), ".tmc") {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// large reports whether a value of the given size is kept on disk only.
+func (d *disk[K]) large(size int64) bool {
+	return d.threshold > 0 && size > d.threshold
+}
+
+// reserve replaces the file of key with f, which commit writes later. Until
+// then reads of key wait for it.
+func (d *disk[K]) reserve(key K, f *spillFile) *spillFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drop(key)
+	f.written = make(chan struct{})
+	d.writes.Add(1)
+	d.files[key] = f
+	d.expiry.schedule(key, f.expires)
+	return f
+}
+
+// commit writes data as the file f reserved for key, then drops the least
+// recently written files until the tier is within its budget. The value is
+// dropped if the file cannot be written, does not fit the budget, or was
+// dropped since it was reserved.
+func (d *disk[K]) commit(key K, f *spillFile, data []byte) {
+	var path string
+	if d.budget <= 0 || int64(len(data)) <= d.budget {
+		path = d.create(data)
+	}
+
+	d.mu.Lock()
+	kept := path != "" && d.files[key] == f
+	if kept {
+		f.path = path
+		f.size = int64(len(data))
+		f.elem = d.lru.PushFront(key)
+		d.size += f.size
+		for d.budget > 0 && d.size > d.budget {
+			d.drop(d.lru.Back().Value.(K))
+		}
+	} else {
+		d.cancel(key, f)
+		if path != "" {
+			d.discard(path)
+		}
+	}
+	d.mu.Unlock()
+
+	d.empty()
+	d.finish(f)
+}
+
+// finish wakes the reads waiting for f to be written.
+func (d *disk[K]) finish(f *spillFile) {
+	close(f.written)
+	d.writes.Done()
+}
+
+// wait waits for the reserved files to be written, then removes the files
+// dropped so far.
+func (d *disk[K]) wait() {
+	d.writes.Wait()
+	d.empty()
+}
+
+// cancel drops the file f reserved for key, unless it was dropped already.
+// The caller holds d.mu.
+func (d *disk[K]) cancel(key K, f *spillFile) {
+	if d.files[key] == f {
+		d.drop(key)
+	}
+}
+
+// create writes data to a new file in dir and returns its path, or "" if it
+// cannot be written.
+func (d *disk[K]) create(data []byte) string {
+	file, err := os.CreateTemp(d.dir, spillPrefix+"*.tmc")
+	if err != nil {
+		return ""
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return ""
+	}
+	return file.Name()
+}
+
+// read returns the content of key's file and a copy of its index entry. A
+// fresh read of an idle value slides its deadline, as a hit in memory does. A
+// value that is not large moves back to memory, so its file is dropped.
+func (d *disk[K]) read(key K, now int64) (data []byte, meta spillFile, ok bool) {
+	d.mu.Lock()
+	f := d.await(key, now)
+	if f != nil && f.expires <= now {
+		d.drop(key)
+	} else if f != nil {
+		if f.idle > 0 && f.deadline > now {
+			deadline := slide(now, f.idle, f.limit)
+			f.expires += deadline - f.deadline
+			f.deadline = deadline
+			d.expiry.schedule(key, f.expires)
+		}
+		meta = *f
+	}
+	d.mu.Unlock()
+	if meta.path == "" {
+		d.empty()
+		return nil, meta, false
+	}
+
+	data, err := os.ReadFile(meta.path)
+	if err != nil || !f.large {
+		d.mu.Lock()
+		d.cancel(key, f)
+		d.mu.Unlock()
+		d.empty()
+	}
+	return data, meta, err == nil
+}
+
+// peek returns the content of key's file and a copy of its index entry if
+// its deadline is after now, leaving the entry as it is.
+func (d *disk[K]) peek(key K, now int64) (data []byte, meta spillFile, ok bool) {
+	d.mu.Lock()
+	if f := d.await(key, now); f != nil && f.deadline > now {
+		meta = *f
+	}
+	d.mu.Unlock()
+	if meta.path == "" {
+		return nil, meta, false
+	}
+	data, err := os.ReadFile(meta.path)
+	return data, meta, err == nil
+}
+
+// await returns the file of key, waiting for it to be written if it is
+// reserved and expires after now. The caller holds d.mu, which is released
+// while waiting.
+func (d *disk[K]) await(key K, now int64) *spillFile {
+	f := d.files[key]
+	for f != nil && f.path == "" && f.expires > now {
+		written := f.written
+		d.mu.Unlock()
+		<-written
+		d.mu.Lock()
+		f = d.files[key]
+	}
+	return f
+}
+
+// keys returns the keys of the files, written or reserved.
+func (d *disk[K]) keys() []K {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]K, 0, len(d.files))
+	for k := range d.files {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// expiring reports whether key has a file, written or reserved, that expires
+// at expires.
+func (d *disk[K]) expiring(key K, expires int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.files[key]
+	return f != nil && f.expires == expires
+}
+
+// delete drops the file of key, if any. Its removal is left to empty, as the
+// caller may hold a shard lock.
+func (d *disk[K]) delete(key K) {
+	d.mu.Lock()
+	d.drop(key)
+	d.mu.Unlock()
+}
+
+// deleteTag drops the files of the values tagged with tag.
+func (d *disk[K]) deleteTag(tag string) {
+	d.mu.Lock()
+	for k, f := range d.files {
+		if hasTag(f.tags, tag) {
+			d.drop(k)
+		}
+	}
+	d.mu.Unlock()
+	d.empty()
+}
+
+// expire drops the files of the values that expired by now.
+func (d *disk[K]) expire(now int64) {
+	d.mu.Lock()
+	for {
+		key, ok := d.expiry.due(now)
+		if !ok {
+			break
+		}
+		d.drop(key)
+	}
+	d.mu.Unlock()
+	d.empty()
+}
+
+// clear drops every file.
+func (d *disk[K]) clear() {
+	d.mu.Lock()
+	for k := range d.files {
+		d.drop(k)
+	}
+	d.mu.Unlock()
+	d.empty()
+}
+
+// drop removes key from the index and queues its file for removal. The
+// caller holds d.mu.
+func (d *disk[K]) drop(key K) {
+	f := d.files[key]
+	if f == nil {
+		return
+	}
+	delete(d.files, key)
+	d.expiry.unschedule(key)
+	if f.elem != nil {
+		d.lru.Remove(f.elem)
+		d.size -= f.size
+	}
+	if f.path != "" {
+		d.discard(f.path)
+	}
+}
+
+// discard queues the file at path for removal. The caller holds d.mu.
+func (d *disk[K]) discard(path string) {
+	d.trash = append(d.trash, path)
+	d.dirty.Store(true)
+}
+
+// empty removes the files dropped so far. It is called with no lock held.
+func (d *disk[K]) empty() {
+	if !d.dirty.Load() {
+		return
+	}
+	d.mu.Lock()
+	trash := d.trash
+	d.trash = nil
+	d.dirty.Store(false)
+	d.mu.Unlock()
+
+	for _, path := range trash {
+		os.Remove(path)
+	}
+}
+
+// encode returns the content of a spill file for the successfully loaded
+// outcome o, and false if o cannot be spilled.
+func (tmc *TMCache[K, V]) encode(o outcome[V]) ([]byte, bool) {
+	var buf bytes.Buffer
+	err := tmc.codec.Encode(&buf, spilled[V]{Value: o.res.value})
+	return buf.Bytes(), err == nil
+}
+
+// spillFile returns the index entry of the successfully loaded outcome o.
+func (o *outcome[V]) spillFile(large bool) *spillFile {
+	return &spillFile{
+		deadline:  o.deadline,
+		expires:   o.expires,
+		refreshAt: o.refreshAt,
+		idle:      o.idle,
+		limit:     o.limit,
+		large:     large,
+		tags:      o.tags,
+	}
+}
+
+// measure sets o.spill to the encoding of o's value if it is large enough to
+// be kept on disk only. The size of a value is its WithMaxCost cost if there
+// is a budget, else its length if it is a []byte or string. It is called
+// before the shard lock is taken, and only encodes the values over the
+// threshold.
+func (tmc *TMCache[K, V]) measure(o *outcome[V]) {
+	if tmc.disk == nil || tmc.disk.threshold <= 0 || o.res.err != nil {
+		return
+	}
+	size := o.cost
+	if tmc.maxCost <= 0 {
+		size = defaultCost(o.res.value)
+	}
+	if !tmc.disk.large(size) {
+		return
+	}
+	if data, ok := tmc.encode(*o); ok {
+		o.spill = data
+	}
+}
+
+// flush writes w to disk, encoding it first if it is an evicted value. It is
+// called with no lock held.
+func (tmc *TMCache[K, V]) flush(w spillWrite[K, V]) {
+	data, ok := w.data, true
+	if data == nil {
+		data, ok = tmc.encode(w.o)
+	}
+	if !ok {
+		tmc.disk.mu.Lock()
+		tmc.disk.cancel(w.key, w.file)
+		tmc.disk.mu.Unlock()
+		tmc.disk.finish(w.file)
+		return
+	}
+	tmc.disk.commit(w.key, w.file, data)
+}
+
+// restore settles the in-flight item i of key with its value on disk, if
+// any, and reports whether it did, counting the read as a hit. A value read back from disk is stale or
+// due for refresh-ahead just like one read from memory.
+func (tmc *TMCache[K, V]) restore(s *shard[K, V], key K, i *item[V]) bool {
+	if tmc.disk == nil {
+		return false
+	}
+	now := time.Now().UnixNano()
+	o, keep, ok := tmc.unspill(key, now)
+	if !ok {
+		return false
+	}
+	tmc.stats.hits.Add(1)
+	s.mu.Lock()
+	if !i.loading {
+		// Set settled i while the file was read.
+		tmc.unlock(s)
+		return true
+	}
+	o.restored = true
+	o.stale = o.deadline <= now
+	if o.stale || o.refreshAt > 0 && o.refreshAt <= now {
+		tmc.refresh(s, key, time.Unix(0, o.expires), i.ttl)
+	}
+	tmc.complete(s, key, i, o, keep)
+	return true
+}
+
+// unspill reads key back from disk, reporting false if it is not there. The
+// outcome is only cached in memory if it is not large.
+func (tmc *TMCache[K, V]) unspill(key K, now int64) (o outcome[V], keep bool, ok bool) {
+	data, meta, ok := tmc.disk.read(key, now)
+	if !ok {
+		return o, false, false
+	}
+	if o, ok = tmc.decode(data, meta); !ok {
+		return o, false, false
+	}
+	keep = tmc.weigh(&o, 0) && !meta.large
+	return o, keep, true
+}
+
+// decode returns the outcome kept in a spill file with the index entry meta.
+func (tmc *TMCache[K, V]) decode(data []byte, meta spillFile) (outcome[V], bool) {
+	var sp spilled[V]
+	if err := tmc.codec.Decode(bytes.NewReader(data), &sp); err != nil {
+		return outcome[V]{}, false
+	}
+	return outcome[V]{
+		res:       result[V]{value: sp.Value},
+		deadline:  meta.deadline,
+		expires:   meta.expires,
+		refreshAt: meta.refreshAt,
+		idle:      meta.idle,
+		limit:     meta.limit,
+		tags:      meta.tags,
+	}, true
+}
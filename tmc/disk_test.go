@@ -0,0 +1,353 @@
+package tmc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func spillFiles(t *testing.T, dir string) int {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestDiskSpillLargeValues(t *testing.T) {
+	dir := t.TempDir()
+	loads := 0
+	cache := New(func(key string) (string, error) {
+		loads++
+		if key == "large" {
+			return strings.Repeat("x", 1000), nil
+		}
+		return key, nil
+	}, hour, WithDiskSpill[string, string](dir, 500, 0))
+
+	cache.Get("large", hour)
+	cache.Get("small", hour)
+	cache.disk.wait()
+	if cache.Has("large") || !cache.Has("small") || spillFiles(t, dir) != 1 {
+		t.Error("Error: expected only the large value on disk")
+	}
+	if val, chit, err := cache.Get("large", hour); len(val) != 1000 || !chit || err != nil || loads != 2 {
+		t.Errorf("Error: Get of a spilled value = %d bytes, %t, %v after %d loads; expected a 1000 byte hit after 2 loads", len(val), chit, err, loads)
+	}
+	if st := cache.Stats(); st.Hits != 1 || st.Misses != 2 {
+		t.Errorf("Error: %d hits, %d misses; expected the read from disk counted as a hit", st.Hits, st.Misses)
+	}
+
+	cache.Close()
+	if n := spillFiles(t, dir); n != 0 {
+		t.Errorf("Error: %d files left after Close", n)
+	}
+}
+
+func TestDiskSpillEvictedValues(t *testing.T) {
+	dir := t.TempDir()
+	loads := 0
+	cache := New(func(key string) (string, error) {
+		loads++
+		return "value " + key, nil
+	}, hour, WithMaxEntries[string, string](1), WithDiskSpill[string, string](dir, 0, 0))
+	defer cache.Close()
+
+	cache.Get("a", hour)
+	cache.Get("b", hour)
+	cache.disk.wait()
+	if cache.Has("a") || spillFiles(t, dir) != 1 {
+		t.Error("Error: expected the evicted value on disk")
+	}
+	if val, _, _ := cache.Get("a", hour); val != "value a" || loads != 2 {
+		t.Errorf("Error: Get of an evicted value = %q after %d loads; expected %q after 2 loads", val, loads, "value a")
+	}
+	if !cache.Has("a") || cache.Has("b") {
+		t.Error("Error: expected the read value back in memory and the other one evicted")
+	}
+
+	cache.Del("b")
+	cache.disk.wait()
+	if n := spillFiles(t, dir); n != 0 {
+		t.Errorf("Error: %d files left after deleting the spilled key", n)
+	}
+}
+
+func TestDiskSpillBudgetAndExpiry(t *testing.T) {
+	dir := t.TempDir()
+	cache := New(func(key string) (string, error) {
+		return strings.Repeat(key, 500), nil
+	}, hour, WithDiskSpill[string, string](dir, 100, 1500))
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Get(key, hour)
+		cache.disk.wait()
+	}
+	if size := cache.disk.size; spillFiles(t, dir) != 2 || size > 1500 {
+		t.Errorf("Error: %d files of %d bytes on disk; expected 2 within the budget", spillFiles(t, dir), size)
+	}
+	if _, ok := cache.disk.files["a"]; ok {
+		t.Error("Error: expected the oldest file deleted first")
+	}
+
+	cache.Get("e", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	cache.routineCleanup()
+	if _, ok := cache.disk.files["e"]; ok {
+		t.Error("Error: expired file not deleted")
+	}
+}
+
+func TestDiskSpillSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "tmc-spill-1.tmc")
+	others := []string{filepath.Join(dir, "notes.txt"), filepath.Join(dir, "data.tmc")}
+	for _, name := range append(others, stale) {
+		if err := os.WriteFile(name, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newCache := func(prefix string) *TMCache[string, string] {
+		return New(func(key string) (string, error) {
+			return prefix + key, nil
+		}, hour, WithMaxEntries[string, string](1), WithDiskSpill[string, string](dir, 0, 0))
+	}
+	first := newCache("first ")
+	defer first.Close()
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("Error: stale spill file not deleted at startup")
+	}
+	for _, name := range others {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("Error: %s, which is not a spill file, was deleted", filepath.Base(name))
+		}
+	}
+
+	first.Get("a", hour)
+	first.Get("b", hour)
+	second := newCache("second ")
+	defer second.Close()
+	second.Get("a", hour)
+	second.Get("b", hour)
+	first.disk.wait()
+	second.disk.wait()
+	if n := spillFiles(t, dir); n != 4 {
+		t.Errorf("Error: %d files in the shared dir; expected 2 spill files and 2 others", n)
+	}
+	if val, _, _ := first.Get("a", hour); val != "first a" {
+		t.Errorf("Error: Get from the first cache = %q; expected %q", val, "first a")
+	}
+	if val, _, _ := second.Get("a", hour); val != "second a" {
+		t.Errorf("Error: Get from the second cache = %q; expected %q", val, "second a")
+	}
+}
+
+func TestDiskSpillGetMany(t *testing.T) {
+	loads := 0
+	var batched []string
+	cache := New(func(key string) (string, error) {
+		loads++
+		return "value " + key, nil
+	}, hour, WithMaxEntries[string, string](1), WithDiskSpill[string, string](t.TempDir(), 0, 0),
+		WithBatchLoader(func(ctx context.Context, keys []string) (map[string]string, error) {
+			batched = append(batched, keys...)
+			values := make(map[string]string)
+			for _, key := range keys {
+				values[key] = "batched " + key
+			}
+			return values, nil
+		}))
+	defer cache.Close()
+
+	cache.Get("a", hour)
+	cache.Get("b", hour)
+	values, err := cache.GetMany([]string{"a", "c"}, hour)
+	if err != nil || values["a"] != "value a" || values["c"] != "batched c" {
+		t.Errorf("Error: GetMany = %v, %v; expected a from disk and c from the batch loader", values, err)
+	}
+	if loads != 2 || len(batched) != 1 {
+		t.Errorf("Error: %d loads and batch of %v; expected 2 loads and a batch of c", loads, batched)
+	}
+}
+
+func TestDiskSpillOnRemove(t *testing.T) {
+	var removals removalLog
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithMaxEntries[string, string](1), WithDiskSpill[string, string](t.TempDir(), 0, 0),
+		WithOnRemove(removals.add))
+
+	cache.Get("a", hour)
+	cache.Get("b", hour)
+	if removed := removals.wait(1); len(removed) != 1 || removed[0] != "a evicted" {
+		t.Errorf("Error: removed %v; expected a evicted when moved to disk", removed)
+	}
+	if val, _, _ := cache.Get("a", hour); val != "a" {
+		t.Errorf("Error: Get of a spilled value = %q; expected %q", val, "a")
+	}
+	removals.wait(2)
+	cache.Close()
+	want := []string{"a evicted", "b evicted", "a closed"}
+	if removed := removals.wait(3); fmt.Sprint(removed) != fmt.Sprint(want) {
+		t.Errorf("Error: removed %v; expected %v, with b on disk not reported again", removed, want)
+	}
+}
+
+func TestDiskSpillSkipsEncoding(t *testing.T) {
+	for _, threshold := range []int64{0, 100} {
+		encodes := 0
+		cache := New(func(key string) (string, error) {
+			return key, nil
+		}, hour, WithDiskSpill[string, string](t.TempDir(), threshold, 0), WithCodec[string, string](countingCodec{&encodes}))
+
+		cache.Get("small", hour)
+		cache.Set("b", "b", hour)
+		if encodes != 0 || !cache.Has("small") || !cache.Has("b") {
+			t.Errorf("Error: %d values encoded with threshold %d; expected 0, and the values in memory", encodes, threshold)
+		}
+		cache.Close()
+	}
+}
+
+// countingCodec is GobCodec counting the values it encodes.
+type countingCodec struct {
+	encodes *int
+}
+
+func (c countingCodec) Encode(w io.Writer, v any) error {
+	*c.encodes++
+	return GobCodec.Encode(w, v)
+}
+
+func (c countingCodec) Decode(r io.Reader, v any) error {
+	return GobCodec.Decode(r, v)
+}
+
+func TestDiskSpillStaleWhileRevalidate(t *testing.T) {
+	var version int64
+	cache := New(func(key string) (string, error) {
+		return fmt.Sprint(atomic.AddInt64(&version, 1), strings.Repeat("x", 1000)), nil
+	}, hour, WithStaleWhileRevalidate[string, string](hour), WithDiskSpill[string, string](t.TempDir(), 500, 0))
+	defer cache.Close()
+
+	cache.Get("key", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	r, err := cache.Fetch(context.Background(), "key", hour)
+	if err != nil || r.Value[0] != '1' || !r.Stale {
+		t.Errorf("Error: Fetch = %.1s, stale %t, %v; expected stale version 1", r.Value, r.Stale, err)
+	}
+	for n := 0; n < 100 && r.Value[0] == '1'; n++ {
+		time.Sleep(time.Millisecond)
+		r, err = cache.Fetch(context.Background(), "key", hour)
+	}
+	if err != nil || r.Value[0] != '2' || r.Stale {
+		t.Errorf("Error: Fetch = %.1s, stale %t, %v; expected fresh version 2", r.Value, r.Stale, err)
+	}
+	if n := atomic.LoadInt64(&version); n != 2 {
+		t.Errorf("Error: %d loads; expected 2", n)
+	}
+}
+
+func TestDiskSpillRefreshAhead(t *testing.T) {
+	var version int64
+	cache := New(func(key string) (int64, error) {
+		return atomic.AddInt64(&version, 1), nil
+	}, hour, WithRefreshAhead[string, int64](0.5), WithMaxEntries[string, int64](1),
+		WithDiskSpill[string, int64](t.TempDir(), 0, 0))
+	defer cache.Close()
+
+	ttl := 100 * time.Millisecond
+	cache.Get("a", ttl)
+	cache.Get("b", ttl)
+	time.Sleep(60 * time.Millisecond)
+
+	val, _, _ := cache.Get("a", ttl)
+	if val != 1 {
+		t.Errorf("Error: Get = %d; expected version 1 from disk", val)
+	}
+	for n := 0; n < 30 && val == 1; n++ {
+		time.Sleep(time.Millisecond)
+		val, _, _ = cache.Get("a", ttl)
+	}
+	if val != 3 {
+		t.Errorf("Error: Get = %d; expected version 3 refreshed ahead", val)
+	}
+}
+
+func TestDiskSpillSetRacesRestore(t *testing.T) {
+	codec := blockingCodec{decoding: make(chan struct{}), release: make(chan struct{})}
+	cache := New(func(key string) (string, error) {
+		return "loaded", nil
+	}, hour, WithStaleWhileRevalidate[string, string](hour), WithMaxEntries[string, string](1),
+		WithDiskSpill[string, string](t.TempDir(), 0, 0), WithCodec[string, string](codec))
+	defer cache.Close()
+
+	cache.Get("key", time.Millisecond)
+	cache.Get("other", hour)
+	time.Sleep(2 * time.Millisecond)
+
+	done := make(chan Result[string])
+	go func() {
+		r, _ := cache.Fetch(context.Background(), "key", hour)
+		done <- r
+	}()
+	<-codec.decoding
+	cache.Set("key", "set", hour)
+	close(codec.release)
+	if r := <-done; r.Value != "set" || r.Stale {
+		t.Errorf("Error: Fetch = %+v; expected the value set while the disk was read, not stale", r)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if val, _ := cache.Peek("key"); val != "set" || cache.Stats().LoadSuccesses != 2 {
+		t.Errorf("Error: Peek = %q after %d loads; expected the set value and no reload", val, cache.Stats().LoadSuccesses)
+	}
+}
+
+// blockingCodec is GobCodec signalling decoding when it starts to decode,
+// and blocking until release is closed.
+type blockingCodec struct {
+	decoding chan struct{}
+	release  chan struct{}
+}
+
+func (c blockingCodec) Encode(w io.Writer, v any) error {
+	return GobCodec.Encode(w, v)
+}
+
+func (c blockingCodec) Decode(r io.Reader, v any) error {
+	c.decoding <- struct{}{}
+	<-c.release
+	return GobCodec.Decode(r, v)
+}
+
+func TestDiskSpillTimeToIdle(t *testing.T) {
+	var loads int64
+	cache := New(func(key string) (string, error) {
+		atomic.AddInt64(&loads, 1)
+		return strings.Repeat("x", 1000), nil
+	}, hour, WithTimeToIdle[string, string](50*time.Millisecond, 0), WithDiskSpill[string, string](t.TempDir(), 500, 0))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	for n := 0; n < 10; n++ {
+		time.Sleep(20 * time.Millisecond)
+		cache.Get("key", hour)
+	}
+	if n := atomic.LoadInt64(&loads); n != 1 {
+		t.Errorf("Error: %d loads of a large value read every 20ms; expected 1", n)
+	}
+	time.Sleep(80 * time.Millisecond)
+	cache.routineCleanup()
+	if len(cache.disk.files) != 0 {
+		t.Error("Error: idle large value not expired")
+	}
+}
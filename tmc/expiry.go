@@ -0,0 +1,97 @@
+package tmc
+
+import "container/heap"
+
+// expiry is a min-heap of the keys of a shard, or of the disk tier, ordered
+// by the time their entries expire, so cleanup only visits the entries that
+// are due instead of sweeping them all. Every key is queued at most once.
+type expiry[K comparable] struct {
+	timers []timer[K]
+	slots  map[K]int // position of each key's timer
+}
+
+// timer schedules the expiry of key at the expires its entry had when
+// queued. Time to idle and Set push expires forward without touching the
+// heap; cleanup queues such keys again when their stale timer fires.
+type timer[K comparable] struct {
+	at  int64
+	key K
+}
+
+func (e *expiry[K]) Len() int           { return len(e.timers) }
+func (e *expiry[K]) Less(a, b int) bool { return e.timers[a].at < e.timers[b].at }
+
+func (e *expiry[K]) Swap(a, b int) {
+	e.timers[a], e.timers[b] = e.timers[b], e.timers[a]
+	e.slots[e.timers[a].key] = a
+	e.slots[e.timers[b].key] = b
+}
+
+func (e *expiry[K]) Push(x any) {
+	t := x.(timer[K])
+	if e.slots == nil {
+		e.slots = make(map[K]int)
+	}
+	e.slots[t.key] = len(e.timers)
+	e.timers = append(e.timers, t)
+}
+
+func (e *expiry[K]) Pop() any {
+	t := e.timers[len(e.timers)-1]
+	e.timers[len(e.timers)-1] = timer[K]{}
+	e.timers = e.timers[:len(e.timers)-1]
+	delete(e.slots, t.key)
+	return t
+}
+
+// schedule queues key for cleanup at, replacing its earlier timer.
+func (e *expiry[K]) schedule(key K, at int64) {
+	if n, ok := e.slots[key]; ok {
+		e.timers[n].at = at
+		heap.Fix(e, n)
+		return
+	}
+	heap.Push(e, timer[K]{at: at, key: key})
+}
+
+// unschedule drops key from the cleanup queue, if queued.
+func (e *expiry[K]) unschedule(key K) {
+	if n, ok := e.slots[key]; ok {
+		heap.Remove(e, n)
+	}
+}
+
+// due returns the first key queued for cleanup by now, if any.
+func (e *expiry[K]) due(now int64) (key K, ok bool) {
+	if len(e.timers) == 0 || e.timers[0].at > now {
+		return key, false
+	}
+	return e.timers[0].key, true
+}
+
+// expire removes the entries of s that expired by now. The caller holds s.mu.
+func (tmc *TMCache[K, V]) expire(s *shard[K, V], now int64) {
+	for {
+		key, ok := s.expiry.due(now)
+		if !ok {
+			return
+		}
+		if i := s.items[key]; i != nil && !i.loading {
+			if i.expired(now) {
+				tmc.forget(s, key, i, Expired)
+			} else {
+				s.expiry.schedule(key, i.expires)
+			}
+			continue
+		}
+		if r, ok := s.store.Get(key); ok {
+			if expires := r.Expires.UnixNano(); expires > now {
+				s.expiry.schedule(key, expires)
+			} else {
+				tmc.remove(s, key, r, Expired)
+			}
+			continue
+		}
+		s.expiry.unschedule(key)
+	}
+}
@@ -0,0 +1,115 @@
+package tmc
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// newHasher returns a seeded hash function for keys of type K that agrees
+// with ==. Strings, integers and floats are hashed directly; other key types,
+// such as structs, are hashed field by field.
+func newHasher[K comparable]() func(key K) uint64 {
+	seed := maphash.MakeSeed()
+	salt := maphash.String(seed, "")
+	return func(key K) uint64 {
+		switch k := any(key).(type) {
+		case string:
+			return maphash.String(seed, k)
+		case int:
+			return mix(uint64(k) ^ salt)
+		case int8:
+			return mix(uint64(k) ^ salt)
+		case int16:
+			return mix(uint64(k) ^ salt)
+		case int32:
+			return mix(uint64(k) ^ salt)
+		case int64:
+			return mix(uint64(k) ^ salt)
+		case uint:
+			return mix(uint64(k) ^ salt)
+		case uint8:
+			return mix(uint64(k) ^ salt)
+		case uint16:
+			return mix(uint64(k) ^ salt)
+		case uint32:
+			return mix(uint64(k) ^ salt)
+		case uint64:
+			return mix(k ^ salt)
+		case uintptr:
+			return mix(uint64(k) ^ salt)
+		case float32:
+			return mix(floatBits(float64(k)) ^ salt)
+		case float64:
+			return mix(floatBits(k) ^ salt)
+		}
+		var h maphash.Hash
+		h.SetSeed(seed)
+		hashValue(&h, reflect.ValueOf(&key).Elem())
+		return h.Sum64()
+	}
+}
+
+// floatBits returns the bits of f, with -0 folded into 0 as they are equal
+// keys.
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
+
+// hashValue writes the comparable value v to h, so that equal values write
+// the same bytes.
+func hashValue(h *maphash.Hash, v reflect.Value) {
+	var b [8]byte
+	switch v.Kind() {
+	case reflect.String:
+		h.WriteString(v.String())
+		return
+	case reflect.Bool:
+		if v.Bool() {
+			b[0] = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		binary.LittleEndian.PutUint64(b[:], v.Uint())
+	case reflect.Float32, reflect.Float64:
+		binary.LittleEndian.PutUint64(b[:], floatBits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		binary.LittleEndian.PutUint64(b[:], floatBits(real(c)))
+		h.Write(b[:])
+		binary.LittleEndian.PutUint64(b[:], floatBits(imag(c)))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.Pointer()))
+	case reflect.Interface:
+		if !v.IsNil() {
+			hashValue(h, v.Elem())
+		}
+		return
+	case reflect.Array:
+		for n := 0; n < v.Len(); n++ {
+			hashValue(h, v.Index(n))
+		}
+		return
+	case reflect.Struct:
+		for n := 0; n < v.NumField(); n++ {
+			hashValue(h, v.Field(n))
+		}
+		return
+	}
+	h.Write(b[:])
+}
+
+// mix is the splitmix64 finalizer.
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
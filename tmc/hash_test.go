@@ -0,0 +1,58 @@
+package tmc
+
+import (
+	"math"
+	"testing"
+)
+
+type point struct {
+	x, y float64
+	name string
+}
+
+func TestHasherAgreesWithEquality(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+
+	floats := newHasher[float64]()
+	if floats(0) != floats(negZero) {
+		t.Error("Error: 0.0 and -0.0 hash differently")
+	}
+	complexes := newHasher[complex128]()
+	if complexes(complex(0, 1)) != complexes(complex(negZero, 1)) {
+		t.Error("Error: complex keys with 0 and -0 parts hash differently")
+	}
+
+	points := newHasher[point]()
+	a := point{x: 0, y: 1, name: "a"}
+	b := point{x: negZero, y: 1, name: "a"}
+	if a != b || points(a) != points(b) {
+		t.Error("Error: equal struct keys hash differently")
+	}
+	if points(a) == points(point{x: 0, y: 1, name: "b"}) {
+		t.Error("Error: struct keys that differ in a string field hash alike")
+	}
+}
+
+func TestNegativeZeroKeyIsCached(t *testing.T) {
+	cache := New(func(key float64) (float64, error) {
+		return key, nil
+	}, hour)
+	defer cache.Close()
+
+	cache.Get(0, hour)
+	if _, chit, _ := cache.Get(math.Copysign(0, -1), hour); !chit {
+		t.Error("Error: Get(-0.0) after Get(0.0) missed")
+	}
+	cache.Del(math.Copysign(0, -1))
+	if cache.Has(0) {
+		t.Error("Error: Del(-0.0) left the 0.0 entry cached")
+	}
+}
+
+func TestStructKeyHashAllocatesOnce(t *testing.T) {
+	hash := newHasher[user]()
+	key := user{id: 1, name: "a"}
+	if n := testing.AllocsPerRun(100, func() { hash(key) }); n > 1 {
+		t.Errorf("Error: hashing a struct key allocates %v times; expected at most once", n)
+	}
+}
@@ -0,0 +1,108 @@
+package tmc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Observer is notified around cache operations, e.g. to trace them. The
+// contexts returned by LookupStart and LoadStart are passed to the matching
+// end callback, and the one from LoadStart also to the loader, so an adapter
+// can start a span in one and end it in the other. Callbacks never run with a
+// cache lock held.
+type Observer[K comparable] interface {
+	LookupStart(ctx context.Context, key K) context.Context
+	LookupEnd(ctx context.Context, key K, hit bool, d time.Duration, err error)
+	LoadStart(ctx context.Context, key K) context.Context
+	LoadEnd(ctx context.Context, key K, d time.Duration, err error)
+	// CoalescedWait is called when a lookup starts waiting on a load that
+	// another lookup started.
+	CoalescedWait(ctx context.Context, key K)
+}
+
+// NopObserver ignores every event. It is the default Observer, and can be
+// embedded to implement only some of the callbacks.
+type NopObserver[K comparable] struct{}
+
+func (NopObserver[K]) LookupStart(ctx context.Context, key K) context.Context {
+	return ctx
+}
+
+func (NopObserver[K]) LookupEnd(ctx context.Context, key K, hit bool, d time.Duration, err error) {}
+
+func (NopObserver[K]) LoadStart(ctx context.Context, key K) context.Context {
+	return ctx
+}
+
+func (NopObserver[K]) LoadEnd(ctx context.Context, key K, d time.Duration, err error) {}
+
+func (NopObserver[K]) CoalescedWait(ctx context.Context, key K) {}
+
+// Op names an Observer callback.
+type Op int
+
+const (
+	OpLookupStart Op = iota + 1
+	OpLookupEnd
+	OpLoadStart
+	OpLoadEnd
+	OpCoalescedWait
+)
+
+// Event is an Observer callback recorded by a Recorder. Hit is only set for
+// OpLookupEnd, and Duration and Err only for the end events.
+type Event[K comparable] struct {
+	Op       Op
+	Key      K
+	Hit      bool
+	Duration time.Duration
+	Err      error
+}
+
+// Recorder is an Observer that keeps every event in memory, for tests.
+type Recorder[K comparable] struct {
+	mu     sync.Mutex
+	events []Event[K]
+}
+
+func (r *Recorder[K]) record(e Event[K]) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+// Events returns the events recorded so far, oldest first.
+func (r *Recorder[K]) Events() []Event[K] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event[K](nil), r.events...)
+}
+
+func (r *Recorder[K]) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
+
+func (r *Recorder[K]) LookupStart(ctx context.Context, key K) context.Context {
+	r.record(Event[K]{Op: OpLookupStart, Key: key})
+	return ctx
+}
+
+func (r *Recorder[K]) LookupEnd(ctx context.Context, key K, hit bool, d time.Duration, err error) {
+	r.record(Event[K]{Op: OpLookupEnd, Key: key, Hit: hit, Duration: d, Err: err})
+}
+
+func (r *Recorder[K]) LoadStart(ctx context.Context, key K) context.Context {
+	r.record(Event[K]{Op: OpLoadStart, Key: key})
+	return ctx
+}
+
+func (r *Recorder[K]) LoadEnd(ctx context.Context, key K, d time.Duration, err error) {
+	r.record(Event[K]{Op: OpLoadEnd, Key: key, Duration: d, Err: err})
+}
+
+func (r *Recorder[K]) CoalescedWait(ctx context.Context, key K) {
+	r.record(Event[K]{Op: OpCoalescedWait, Key: key})
+}
@@ -0,0 +1,82 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestRecorderObservesLookupsAndLoads(t *testing.T) {
+	errBad := errors.New("bad")
+	rec := &Recorder[string]{}
+	cache := NewContext(func(ctx context.Context, key string) (string, error) {
+		if ctx.Value(ctxKey{}) != "trace" {
+			t.Error("Error: loader context lost the caller's values")
+		}
+		return "", errBad
+	}, hour, WithObserver[string, string](rec))
+	defer cache.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "trace")
+	cache.GetContext(ctx, "key", hour)
+	cache.GetContext(ctx, "key", hour)
+
+	var ops []Op
+	for _, e := range rec.Events() {
+		ops = append(ops, e.Op)
+		if e.Key != "key" {
+			t.Errorf("Error: event key = %q; expected 'key'", e.Key)
+		}
+		if e.Op == OpLoadEnd && e.Err != errBad {
+			t.Errorf("Error: load end err = %v; expected %v", e.Err, errBad)
+		}
+	}
+	want := []Op{OpLookupStart, OpLoadStart, OpLoadEnd, OpLookupEnd, OpLookupStart, OpLookupEnd}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("Error: ops = %v; expected %v", ops, want)
+	}
+	if events := rec.Events(); events[3].Hit || !events[5].Hit {
+		t.Error("Error: expected a miss followed by a hit")
+	}
+}
+
+func TestRecorderObservesCoalescedWaits(t *testing.T) {
+	release := make(chan struct{})
+	rec := &Recorder[string]{}
+	cache := New(func(key string) (string, error) {
+		<-release
+		return key, nil
+	}, hour, WithObserver[string, string](rec))
+	defer cache.Close()
+
+	done := make(chan struct{})
+	get := func() {
+		cache.Get("key", hour)
+		done <- struct{}{}
+	}
+	go get()
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go get()
+	for cache.Stats().Coalesced == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	<-done
+
+	coalesced := 0
+	for _, e := range rec.Events() {
+		if e.Op == OpCoalescedWait {
+			coalesced++
+		}
+	}
+	if coalesced != 1 {
+		t.Errorf("Error: %d coalesced waits recorded; expected 1", coalesced)
+	}
+}
@@ -0,0 +1,202 @@
+package tmc
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Option configures a TMCache at construction.
+type Option[K comparable, V any] func(*TMCache[K, V])
+
+// WithErrorTTL caches loader errors for d instead of the ttl passed to Get.
+// With d <= 0 errors are not cached at all; callers waiting on the failed load
+// still receive the error.
+func WithErrorTTL[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.errTTL = &d
+	}
+}
+
+// WithPermanentErrors caches only the loader errors for which permanent
+// returns true, such as a "not found" error. Any other error is handed to the
+// callers waiting on the load and then forgotten.
+func WithPermanentErrors[K comparable, V any](permanent func(err error) bool) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.permanent = permanent
+	}
+}
+
+// WithMaxEntries bounds the cache to n finished entries, cached errors
+// included. When a load would exceed it, the least recently used entry is
+// evicted.
+func WithMaxEntries[K comparable, V any](n int) Option[K, V] {
+	return WithPolicy[K, V](NewLRU[K](n))
+}
+
+// WithPolicy bounds the cache with an eviction policy such as NewLRU or
+// NewTinyLFU. A policy instance must not be shared between caches.
+func WithPolicy[K comparable, V any](p Policy[K]) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.policy = p
+	}
+}
+
+// WithMaxCost bounds the total cost of the cached values to max. cost is
+// evaluated once a load finishes; if nil, the cost of a []byte or string is
+// its length and any other value costs 1. A cached error costs 1. Entries
+// are evicted through the policy (LRU unless WithPolicy is given) until the
+// cache is within budget, and a value costing more than max is returned to
+// its callers uncached.
+func WithMaxCost[K comparable, V any](max int64, cost func(value V) int64) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		if cost == nil {
+			cost = defaultCost[V]
+		}
+		tmc.maxCost = max
+		tmc.cost = cost
+	}
+}
+
+func defaultCost[V any](value V) int64 {
+	switch v := any(value).(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	}
+	return 1
+}
+
+// WithShards splits the key space across n independently locked shards. The
+// default is 16; with one shard keys are not hashed at all.
+func WithShards[K comparable, V any](n int) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.nshards = n
+	}
+}
+
+// WithHasher spreads keys across shards by hash instead of the built-in
+// hasher. Keys that are == must hash alike.
+func WithHasher[K comparable, V any](hash func(key K) uint64) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.hash = hash
+	}
+}
+
+// WithStaleWhileRevalidate keeps serving a value for grace after its TTL.
+// The first read in that period starts a single background reload, and Fetch
+// reports such values as stale. If the reload fails, the stale value is kept
+// until the grace period ends.
+func WithStaleWhileRevalidate[K comparable, V any](grace time.Duration) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.staleGrace = grace
+	}
+}
+
+// WithRefreshAhead reloads an entry in the background once fraction of its
+// TTL has passed and it is read again, e.g. 0.8 for the last fifth of the
+// TTL. The old value is served until the reload finishes, so keys that are
+// read continuously never block on a miss.
+func WithRefreshAhead[K comparable, V any](fraction float64) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.refreshAhead = fraction
+	}
+}
+
+// WithTimeToIdle expires successfully loaded values once they have not been
+// read for idle, instead of at the ttl passed to Get. Every fresh hit pushes
+// the deadline forward by idle, but never past maxAge after the load when
+// maxAge > 0. EntryOptions may override both per entry.
+func WithTimeToIdle[K comparable, V any](idle, maxAge time.Duration) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.idle = idle
+		tmc.maxAge = maxAge
+	}
+}
+
+// WithTTLJitter spreads the deadlines of entries loaded with the same ttl
+// over ttl ± fraction*ttl, e.g. 0.1 for ±10%, so keys warmed together do not
+// all expire on the same tick. fraction is clamped to [0, maxJitter], so no
+// TTL is jittered down to nothing. random returns values in [0, 1) and must
+// be safe for concurrent use; if nil, math/rand is used.
+func WithTTLJitter[K comparable, V any](fraction float64, random func() float64) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		if random == nil {
+			random = rand.Float64
+		}
+		if fraction < 0 {
+			fraction = 0
+		} else if fraction > maxJitter {
+			fraction = maxJitter
+		}
+		tmc.jitter = fraction
+		tmc.random = random
+	}
+}
+
+// maxJitter is the largest fraction WithTTLJitter spreads TTLs by.
+const maxJitter = 0.9
+
+// WithOnRemove calls fn for every successfully loaded value that leaves the
+// cache, e.g. to release resources the value holds. fn runs after the cache
+// lock is released, so it may call back into the cache.
+func WithOnRemove[K comparable, V any](fn func(key K, value V, reason RemovalReason)) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.onRemove = fn
+	}
+}
+
+// WithObserver reports lookups and loads to o, e.g. to trace them.
+func WithObserver[K comparable, V any](o Observer[K]) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.observer = o
+	}
+}
+
+// WithCodec sets the codec SaveTo and LoadFrom encode snapshots with. The
+// default is GobCodec.
+func WithCodec[K comparable, V any](c Codec) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.codec = c
+	}
+}
+
+// WithStore keeps the values of each shard in a Store returned by newStore
+// instead of a map.
+func WithStore[K comparable, V any](newStore func() Store[K, V]) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.newStore = newStore
+	}
+}
+
+// WithDiskSpill adds a disk tier of files in dir, which must exist. Values
+// whose size is over threshold are kept on disk only; the size of a value is
+// its WithMaxCost cost if the cache has a budget, else the length of a []byte
+// or string, and other values are never large. Values evicted from memory
+// are moved there too. Get and GetMany read them back instead of calling the
+// loader; Peek and Has only see the values in memory. Files are deleted when
+// their value expires or is deleted, and when the cache is closed. With
+// budget > 0 the least recently written files are deleted to keep their
+// total size within budget bytes; threshold <= 0 only spills evicted values.
+// Only the values written to disk are encoded, with the WithCodec codec, and
+// files are written after the cache lock is released.
+//
+// Caches of one process may share dir. The spill files an earlier process
+// left in dir are deleted when the first cache using it starts, so dir must
+// not be shared with other processes. A value moved to disk is reported to
+// WithOnRemove as Evicted when it leaves memory, as with no disk tier; its
+// file is deleted without a callback. Large values are never kept in memory,
+// so, like values that are not cached, they are not reported.
+func WithDiskSpill[K comparable, V any](dir string, threshold, budget int64) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.disk = newDisk[K](dir, threshold, budget)
+	}
+}
+
+// WithBatchLoader makes GetMany load all of its missing keys with a single
+// call of batch instead of one loader call per key.
+func WithBatchLoader[K comparable, V any](batch BatchLoader[K, V]) Option[K, V] {
+	return func(tmc *TMCache[K, V]) {
+		tmc.batch = batch
+	}
+}
@@ -0,0 +1,294 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+func TestErrorsNotCached(t *testing.T) {
+	calls := 0
+	cache := New(func(key string) (string, error) {
+		calls++
+		return "", errors.New("transient")
+	}, hour, WithErrorTTL[string, string](0))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	_, chit, err := cache.Get("key", hour)
+	if err == nil {
+		t.Error("Error: err = nil; expected loader error")
+	} else if chit != false || calls != 2 {
+		t.Errorf("Error: chit = %t, calls = %d; expected false, 2", chit, calls)
+	}
+}
+
+func TestErrorTTL(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return "", errNotFound
+	}, hour, WithErrorTTL[string, string](10*time.Millisecond))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	if _, chit, _ := cache.Get("key", hour); chit != true {
+		t.Error("Error: cache hit = false within error ttl; expected true")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, chit, _ := cache.Get("key", hour); chit != false {
+		t.Error("Error: cache hit = true past error ttl; expected false")
+	}
+}
+
+func TestOnlyPermanentErrorsCached(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		if key == "missing" {
+			return "", errNotFound
+		}
+		return "", errors.New("transient")
+	}, hour, WithPermanentErrors[string, string](func(err error) bool {
+		return errors.Is(err, errNotFound)
+	}))
+	defer cache.Close()
+
+	for _, key := range []string{"missing", "flaky"} {
+		cache.Get(key, hour)
+	}
+	if _, chit, err := cache.Get("missing", hour); chit != true || err != errNotFound {
+		t.Errorf("Error: chit = %t, err = %v; expected true, not found", chit, err)
+	}
+	if _, chit, _ := cache.Get("flaky", hour); chit != false {
+		t.Error("Error: transient error was cached")
+	}
+}
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithMaxEntries[string, string](2))
+	defer cache.Close()
+
+	cache.Get("a", hour)
+	cache.Get("b", hour)
+	cache.Get("a", hour)
+	cache.Get("c", hour)
+
+	for _, tc := range []struct {
+		key  string
+		chit bool
+	}{{"a", true}, {"c", true}, {"b", false}} {
+		if _, chit, _ := cache.Get(tc.key, hour); chit != tc.chit {
+			t.Errorf("Error: cache hit for %q = %t; expected %t", tc.key, chit, tc.chit)
+		}
+	}
+}
+
+func TestMaxEntriesOrdersHitsAcrossShards(t *testing.T) {
+	for n := 0; n < 50; n++ {
+		cache := New(func(key string) (string, error) {
+			return key, nil
+		}, hour, WithMaxEntries[string, string](2))
+
+		a, b, c := fmt.Sprint("a", n), fmt.Sprint("b", n), fmt.Sprint("c", n)
+		for _, key := range []string{a, b, b, a, c} {
+			cache.Get(key, hour)
+		}
+		if !cache.Has(a) || cache.Has(b) {
+			t.Fatalf("Error: keys %q, %q cached = %t, %t; expected true, false", a, b, cache.Has(a), cache.Has(b))
+		}
+		cache.Close()
+	}
+}
+
+func TestMaxEntriesBoundsCachedErrors(t *testing.T) {
+	cache := New(func(key int) (string, error) {
+		return "", errNotFound
+	}, hour, WithMaxEntries[int, string](10))
+	defer cache.Close()
+
+	for key := 0; key < 1000; key++ {
+		cache.Get(key, hour)
+	}
+	if st := cache.Stats(); st.Entries != 10 || st.Evictions != 990 {
+		t.Errorf("Error: %d entries, %d evictions; expected 10, 990", st.Entries, st.Evictions)
+	}
+	if _, chit, err := cache.Get(999, hour); !chit || err != errNotFound {
+		t.Errorf("Error: Get = %t, %v; expected the cached error", chit, err)
+	}
+}
+
+func TestMaxCostEvictsUntilWithinBudget(t *testing.T) {
+	cache := New(func(key string) ([]byte, error) {
+		return make([]byte, len(key)*10), nil
+	}, hour, WithMaxCost[string, []byte](50, nil))
+	defer cache.Close()
+
+	cache.Get("a", hour)   // 10 bytes
+	cache.Get("bb", hour)  // 20 bytes
+	cache.Get("ccc", hour) // 30 bytes, evicts "a"
+
+	for _, tc := range []struct {
+		key  string
+		chit bool
+	}{{"bb", true}, {"ccc", true}, {"a", false}} {
+		if _, chit, _ := cache.Get(tc.key, hour); chit != tc.chit {
+			t.Errorf("Error: cache hit for %q = %t; expected %t", tc.key, chit, tc.chit)
+		}
+	}
+}
+
+func TestMaxCostBoundsCachedErrors(t *testing.T) {
+	cache := New(func(key int) ([]byte, error) {
+		return nil, errNotFound
+	}, hour, WithMaxCost[int, []byte](10, nil))
+	defer cache.Close()
+
+	for key := 0; key < 100; key++ {
+		cache.Get(key, hour)
+	}
+	if n := cache.Stats().Entries; n != 10 {
+		t.Errorf("Error: %d entries; expected 10", n)
+	}
+}
+
+func TestValueOverBudgetIsNotCached(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithMaxCost[string, string](4, nil))
+	defer cache.Close()
+
+	cache.Get("too long", hour)
+	val, chit, _ := cache.Get("too long", hour)
+	if chit != false {
+		t.Error("Error: cache hit = true for a value over budget; expected false")
+	} else if val != "too long" {
+		t.Error("Error: expected val = 'too long'")
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version int64
+	cache := New(func(key string) (int64, error) {
+		return atomic.AddInt64(&version, 1), nil
+	}, hour, WithStaleWhileRevalidate[string, int64](hour))
+	defer cache.Close()
+
+	cache.Get("key", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	r, err := cache.Fetch(context.Background(), "key", hour)
+	if err != nil || r.Value != 1 || !r.Stale || !r.Hit {
+		t.Errorf("Error: Fetch = %+v, %v; expected stale hit of version 1", r, err)
+	}
+
+	for n := 0; n < 100 && r.Value < 2; n++ {
+		time.Sleep(time.Millisecond)
+		r, err = cache.Fetch(context.Background(), "key", hour)
+	}
+	if err != nil || r.Value != 2 || r.Stale {
+		t.Errorf("Error: Fetch = %+v, %v; expected fresh version 2", r, err)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var version int64
+	cache := New(func(key string) (int64, error) {
+		return atomic.AddInt64(&version, 1), nil
+	}, hour, WithRefreshAhead[string, int64](0.5))
+	defer cache.Close()
+
+	ttl := 100 * time.Millisecond
+	cache.Get("key", ttl)
+	time.Sleep(60 * time.Millisecond)
+
+	val, chit, _ := cache.Get("key", ttl)
+	if val != 1 || !chit {
+		t.Errorf("Error: Get = %d, %t; expected version 1 from cache", val, chit)
+	}
+	for n := 0; n < 30 && val < 2; n++ {
+		time.Sleep(time.Millisecond)
+		val, chit, _ = cache.Get("key", ttl)
+	}
+	if val != 2 || !chit {
+		t.Errorf("Error: Get = %d, %t; expected refreshed version 2 from cache", val, chit)
+	}
+}
+
+func TestTimeToIdle(t *testing.T) {
+	var loads int64
+	cache := New(func(key string) (int64, error) {
+		return atomic.AddInt64(&loads, 1), nil
+	}, hour, WithTimeToIdle[string, int64](50*time.Millisecond, 0))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	for n := 0; n < 5; n++ {
+		time.Sleep(20 * time.Millisecond)
+		if !cache.Has("key") {
+			t.Fatal("Error: entry expired while being read")
+		}
+		cache.Get("key", hour)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if cache.Has("key") {
+		t.Error("Error: idle entry not expired")
+	}
+}
+
+func TestTimeToIdleMaxAge(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithTimeToIdle[string, string](50*time.Millisecond, 100*time.Millisecond))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	reads := 0
+	for ; reads < 10; reads++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, chit, _ := cache.Get("key", hour); !chit {
+			break
+		}
+	}
+	if reads < 3 || reads == 10 {
+		t.Errorf("Error: entry read every 20ms missed after %d reads, expected at its 100ms max age", reads+1)
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	rolls := []float64{0, 0.999}
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithTTLJitter[string, string](0.5, func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}))
+	defer cache.Close()
+
+	cache.Get("short", second)
+	cache.Get("long", second)
+	time.Sleep(750 * time.Millisecond)
+	if cache.Has("short") {
+		t.Error("Error: entry outlived its TTL jittered down to 500ms")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if !cache.Has("long") {
+		t.Error("Error: entry expired before its TTL jittered up to 1.5s")
+	}
+}
+
+func TestTTLJitterClamped(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithTTLJitter[string, string](2, func() float64 { return 0 }))
+	defer cache.Close()
+
+	cache.Get("key", hour)
+	if !cache.Has("key") {
+		t.Error("Error: entry not cached with a jitter fraction over 1")
+	}
+}
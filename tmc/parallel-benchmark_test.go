@@ -0,0 +1,41 @@
+package tmc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// Run with -cpu 1,2,4,8 to see throughput scale with GOMAXPROCS.
+func BenchmarkParallelGet(b *testing.B) {
+	const keys = 1 << 16
+	cases := []struct {
+		name string
+		opts []Option[int, int]
+	}{
+		{"shards=1", []Option[int, int]{WithShards[int, int](1)}},
+		{fmt.Sprintf("shards=%d", defaultShards), nil},
+		{fmt.Sprintf("shards=%d/bounded", defaultShards), []Option[int, int]{WithMaxEntries[int, int](keys)}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			cache := New(func(key int) (int, error) {
+				return key, nil
+			}, hour, c.opts...)
+			defer cache.Close()
+			for key := 0; key < keys; key++ {
+				cache.Get(key, hour)
+			}
+
+			var seed int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				key := int(atomic.AddInt64(&seed, 7919))
+				for pb.Next() {
+					cache.Get(key%keys, hour)
+					key++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package tmc
+
+import "container/list"
+
+// Policy decides which finished entries a bounded cache keeps. Entries that
+// are still loading are never handed to a Policy, so they cannot be evicted.
+// The cache serializes all calls.
+type Policy[K comparable] interface {
+	// Add records a newly cached key and returns the keys to evict to make
+	// room for it. The result may include key itself if it is not admitted.
+	Add(key K) (evicted []K)
+	// Access records a cache hit on key.
+	Access(key K)
+	// Remove forgets a key that left the cache for another reason. Unknown
+	// keys are ignored.
+	Remove(key K)
+	// Victim removes and returns the key to evict next when the cache is over
+	// its cost budget. It reports false if the policy holds no keys.
+	Victim() (key K, ok bool)
+}
+
+type lru[K comparable] struct {
+	capacity int
+	ll       *list.List
+	elems    map[K]*list.Element
+}
+
+// NewLRU returns a Policy that keeps the capacity most recently used keys.
+// With capacity <= 0 the number of keys is not bounded.
+func NewLRU[K comparable](capacity int) Policy[K] {
+	return &lru[K]{
+		capacity: capacity,
+		ll:       list.New(),
+		elems:    make(map[K]*list.Element),
+	}
+}
+
+func (p *lru[K]) Add(key K) []K {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+		return nil
+	}
+	p.elems[key] = p.ll.PushFront(key)
+
+	var evicted []K
+	for p.capacity > 0 && p.ll.Len() > p.capacity {
+		k := p.ll.Remove(p.ll.Back()).(K)
+		delete(p.elems, k)
+		evicted = append(evicted, k)
+	}
+	return evicted
+}
+
+func (p *lru[K]) Access(key K) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lru[K]) Remove(key K) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lru[K]) Victim() (K, bool) {
+	e := p.ll.Back()
+	if e == nil {
+		var zero K
+		return zero, false
+	}
+	key := p.ll.Remove(e).(K)
+	delete(p.elems, key)
+	return key, true
+}
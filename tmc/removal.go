@@ -0,0 +1,33 @@
+package tmc
+
+// RemovalReason tells an OnRemove callback why an entry left the cache.
+type RemovalReason int
+
+const (
+	// Expired entries outlived their TTL.
+	Expired RemovalReason = iota + 1
+	// Deleted entries were removed by Del, DelTag or EraseAll.
+	Deleted
+	// Evicted entries were dropped by the eviction policy.
+	Evicted
+	// Replaced entries were superseded by Set or a background reload.
+	Replaced
+	// Closed entries were dropped by Close.
+	Closed
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Deleted:
+		return "deleted"
+	case Evicted:
+		return "evicted"
+	case Replaced:
+		return "replaced"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
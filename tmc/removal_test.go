@@ -0,0 +1,105 @@
+package tmc
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnRemoveReasons(t *testing.T) {
+	var removals removalLog
+	var cache *TMCache[string, string]
+	cache = New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithMaxEntries[string, string](2), WithOnRemove(func(key, value string, reason RemovalReason) {
+		cache.Del("z") // calling back into the cache must not deadlock
+		removals.add(key, value, reason)
+	}))
+
+	cache.Get("x", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	cache.Get("x", hour)
+	cache.Get("y", hour)
+	cache.Del("y")
+	cache.Get("a", hour)
+	cache.Get("b", hour)
+	removals.wait(3)
+	cache.Close()
+
+	got := make(map[string]int)
+	for _, r := range removals.wait(5) {
+		got[r]++
+	}
+	want := map[string]int{
+		"x expired": 1,
+		"y deleted": 1,
+		"x evicted": 1,
+		"a closed":  1,
+		"b closed":  1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Error: removals = %v; expected %v", got, want)
+	}
+}
+
+func TestOnRemoveAfterWaiters(t *testing.T) {
+	started, proceed, unblock := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	cache := New(func(key string) (string, error) {
+		if key == "b" {
+			close(started)
+			<-proceed
+		}
+		return key, nil
+	}, hour, WithMaxEntries[string, string](1), WithOnRemove(func(key, value string, reason RemovalReason) {
+		<-unblock
+	}))
+	defer cache.Close()
+	defer close(unblock)
+
+	cache.Get("a", hour)
+	go cache.Get("b", hour) // evicts a, then blocks in the callback
+	<-started
+	waited := make(chan string)
+	go func() {
+		val, _, _ := cache.Get("b", hour)
+		waited <- val
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(proceed)
+
+	select {
+	case val := <-waited:
+		if val != "b" {
+			t.Errorf("Error: waiter got %q; expected %q", val, "b")
+		}
+	case <-time.After(time.Second):
+		t.Error("Error: waiter blocked on the OnRemove callback of the load")
+	}
+}
+
+// removalLog records OnRemove callbacks, which run once the read that caused
+// them has returned.
+type removalLog struct {
+	mu      sync.Mutex
+	removed []string
+}
+
+func (l *removalLog) add(key, value string, reason RemovalReason) {
+	l.mu.Lock()
+	l.removed = append(l.removed, key+" "+reason.String())
+	l.mu.Unlock()
+}
+
+// wait returns the callbacks recorded so far once there are n of them, or
+// after a second.
+func (l *removalLog) wait(n int) []string {
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		removed := append([]string(nil), l.removed...)
+		l.mu.Unlock()
+		if len(removed) >= n || time.Since(start) > time.Second {
+			return removed
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tmc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Codec encodes the entries of a snapshot written by SaveTo. The values of
+// untyped caches lose their concrete type in JSON, and must be registered
+// with gob.Register for gob.
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// GobCodec encodes snapshots with encoding/gob. It is the default.
+var GobCodec Codec = gobCodec{}
+
+// JSONCodec encodes snapshots with encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(w io.Writer, v any) error { return gob.NewEncoder(w).Encode(v) }
+func (gobCodec) Decode(r io.Reader, v any) error { return gob.NewDecoder(r).Decode(v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+// ErrBadSnapshot is returned by LoadFrom for input that is not a snapshot or
+// fails its checksum.
+var ErrBadSnapshot = errors.New("tmc: bad snapshot")
+
+// A snapshot is the magic, the format version and the length of the encoded
+// entries, followed by the entries and their CRC-32 (IEEE). Integers are big
+// endian.
+const (
+	snapshotMagic   = "TMCS"
+	snapshotVersion = 1
+)
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint32
+	Length  uint64
+}
+
+type snapshotEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+	// Deadline is the wall clock time the entry expires at. Idle entries
+	// also keep their time to idle and the cap it slides up to, others the
+	// time they are refreshed ahead at, if any.
+	Deadline time.Time
+	Idle     time.Duration
+	Limit    time.Time
+	Refresh  time.Time
+	Tags     []string
+}
+
+// SaveTo writes the successfully loaded, unexpired entries to w in the
+// codec set by WithCodec, including those kept on disk by WithDiskSpill.
+// Their expiry is kept as an absolute time, so the snapshot can be restored
+// with LoadFrom after a restart.
+func (tmc *TMCache[K, V]) SaveTo(w io.Writer) error {
+	var entries []snapshotEntry[K, V]
+	saved := make(map[K]bool)
+	for _, s := range tmc.shards {
+		now := time.Now().UnixNano()
+		s.mu.Lock()
+		s.store.Range(func(k K, r Record[V]) bool {
+			if r.Deadline.UnixNano() <= now {
+				return true
+			}
+			entries = append(entries, newSnapshotEntry(k, r))
+			if tmc.disk != nil {
+				saved[k] = true
+			}
+			return true
+		})
+		s.mu.Unlock()
+	}
+	if tmc.disk != nil {
+		// Files are read with no lock held, like Get reads them.
+		for _, k := range tmc.disk.keys() {
+			if saved[k] {
+				continue
+			}
+			data, meta, ok := tmc.disk.peek(k, time.Now().UnixNano())
+			if !ok {
+				continue
+			}
+			if o, ok := tmc.decode(data, meta); ok {
+				entries = append(entries, newSnapshotEntry(k, o.record()))
+			}
+		}
+	}
+
+	var payload bytes.Buffer
+	if err := tmc.codec.Encode(&payload, entries); err != nil {
+		return err
+	}
+	h := snapshotHeader{Version: snapshotVersion, Length: uint64(payload.Len())}
+	copy(h.Magic[:], snapshotMagic)
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+}
+
+func newSnapshotEntry[K comparable, V any](key K, r Record[V]) snapshotEntry[K, V] {
+	return snapshotEntry[K, V]{
+		Key:      key,
+		Value:    r.Value,
+		Deadline: r.Deadline,
+		Idle:     r.Idle,
+		Limit:    r.Limit,
+		Refresh:  r.Refresh,
+		Tags:     r.Tags,
+	}
+}
+
+// LoadFrom caches the entries of a snapshot written by SaveTo with the same
+// codec, replacing cached entries of the same keys, as Set would. Entries
+// that expired in the meantime are skipped.
+func (tmc *TMCache[K, V]) LoadFrom(r io.Reader) error {
+	var h snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if string(h.Magic[:]) != snapshotMagic {
+		return ErrBadSnapshot
+	}
+	if h.Version != snapshotVersion {
+		return fmt.Errorf("tmc: unsupported snapshot version %d", h.Version)
+	}
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, r, int64(h.Length)); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if sum != crc32.ChecksumIEEE(payload.Bytes()) {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	var entries []snapshotEntry[K, V]
+	if err := tmc.codec.Decode(&payload, &entries); err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	for _, e := range entries {
+		deadline := e.Deadline.UnixNano()
+		if deadline <= now {
+			continue
+		}
+		o := outcome[V]{
+			res:      result[V]{value: e.Value},
+			deadline: deadline,
+			expires:  deadline + int64(tmc.staleGrace),
+			tags:     e.Tags,
+			idle:     int64(e.Idle),
+		}
+		if !e.Limit.IsZero() {
+			o.limit = e.Limit.UnixNano()
+		}
+		if !e.Refresh.IsZero() {
+			o.refreshAt = e.Refresh.UnixNano()
+		}
+		keep := tmc.weigh(&o, 0)
+		if keep {
+			tmc.measure(&o)
+		}
+		tmc.put(e.Key, o, keep)
+	}
+	return nil
+}
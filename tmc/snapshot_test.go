@@ -0,0 +1,132 @@
+package tmc
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"gob": GobCodec, "json": JSONCodec} {
+		t.Run(name, func(t *testing.T) {
+			loader := func(key string) (string, error) {
+				return "loaded " + key, nil
+			}
+			saved := New(loader, hour, WithCodec[string, string](codec))
+			defer saved.Close()
+			saved.Set("a", "value a", hour)
+			saved.Set("b", "value b", hour)
+			saved.Set("short", "value", 10*time.Millisecond)
+
+			var buf bytes.Buffer
+			if err := saved.SaveTo(&buf); err != nil {
+				t.Fatalf("Error: SaveTo: %v", err)
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			restored := New(loader, hour, WithCodec[string, string](codec))
+			defer restored.Close()
+			if err := restored.LoadFrom(&buf); err != nil {
+				t.Fatalf("Error: LoadFrom: %v", err)
+			}
+			for key, want := range map[string]string{"a": "value a", "b": "value b"} {
+				if val, ok := restored.Peek(key); !ok || val != want {
+					t.Errorf("Error: Peek(%q) = %q, %t; expected %q", key, val, ok, want)
+				}
+			}
+			if restored.Has("short") {
+				t.Error("Error: expired entry restored")
+			}
+		})
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour)
+	defer cache.Close()
+	cache.Set("key", "value", hour)
+
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatalf("Error: SaveTo: %v", err)
+	}
+	data := buf.Bytes()
+	data[len(data)-5] ^= 0xff
+	if err := cache.LoadFrom(bytes.NewReader(data)); !errors.Is(err, ErrBadSnapshot) {
+		t.Errorf("Error: LoadFrom of a corrupt snapshot = %v; expected ErrBadSnapshot", err)
+	}
+
+	data[7] = snapshotVersion + 1
+	if err := cache.LoadFrom(bytes.NewReader(data)); err == nil || errors.Is(err, ErrBadSnapshot) {
+		t.Errorf("Error: LoadFrom of a future version = %v; expected a version error", err)
+	}
+}
+
+func TestSnapshotRestoresLikeSet(t *testing.T) {
+	loader := func(key string) (string, error) {
+		return key, nil
+	}
+	saved := New(loader, hour, WithRefreshAhead[string, string](0.5))
+	defer saved.Close()
+	saved.Set("hot", "value", hour)
+	saved.Set("large", strings.Repeat("x", 1000), hour)
+
+	var buf bytes.Buffer
+	if err := saved.SaveTo(&buf); err != nil {
+		t.Fatalf("Error: SaveTo: %v", err)
+	}
+
+	dir := t.TempDir()
+	restored := New(loader, hour, WithRefreshAhead[string, string](0.5), WithDiskSpill[string, string](dir, 500, 0))
+	defer restored.Close()
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatalf("Error: LoadFrom: %v", err)
+	}
+	want, _ := saved.shard("hot").store.Get("hot")
+	if got, _ := restored.shard("hot").store.Get("hot"); !got.Refresh.Equal(want.Refresh) || got.Refresh.IsZero() {
+		t.Errorf("Error: restored refresh-ahead time = %v; expected %v", got.Refresh, want.Refresh)
+	}
+	if restored.Has("large") || spillFiles(t, dir) != 1 {
+		t.Error("Error: expected the restored large value on disk only")
+	}
+	if val, _, _ := restored.Get("large", hour); len(val) != 1000 {
+		t.Errorf("Error: Get of the restored large value = %d bytes; expected 1000", len(val))
+	}
+}
+
+func TestSnapshotSavesDiskValues(t *testing.T) {
+	loader := func(key string) (string, error) {
+		return key, nil
+	}
+	dir := t.TempDir()
+	saved := New(loader, hour, WithMaxEntries[string, string](1), WithDiskSpill[string, string](dir, 500, 0))
+	defer saved.Close()
+	saved.Set("large", strings.Repeat("x", 1000), hour)
+	saved.Set("a", "evicted", hour)
+	saved.Set("b", "in memory", hour)
+	saved.disk.wait()
+
+	var buf bytes.Buffer
+	if err := saved.SaveTo(&buf); err != nil {
+		t.Fatalf("Error: SaveTo: %v", err)
+	}
+	if n := spillFiles(t, dir); n != 2 {
+		t.Errorf("Error: %d files on disk after SaveTo; expected 2", n)
+	}
+
+	restored := New(loader, hour)
+	defer restored.Close()
+	if err := restored.LoadFrom(&buf); err != nil {
+		t.Fatalf("Error: LoadFrom: %v", err)
+	}
+	want := map[string]string{"large": strings.Repeat("x", 1000), "a": "evicted", "b": "in memory"}
+	for key, value := range want {
+		if val, ok := restored.Peek(key); !ok || val != value {
+			t.Errorf("Error: restored %q = %.20q, %v; expected %.20q", key, val, ok, value)
+		}
+	}
+}
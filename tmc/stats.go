@@ -0,0 +1,101 @@
+package tmc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of a cache's counters since it was created.
+type Stats struct {
+	// Hits counts reads answered by a cached entry, stale ones and ones read
+	// back from disk included.
+	Hits int64
+	// Misses counts reads that started a load.
+	Misses int64
+	// Coalesced counts reads that waited on a load started by another read.
+	Coalesced int64
+	// LoadSuccesses and LoadFailures count loaded keys, background reloads
+	// included, counting each key of a batch load. Panics count as failures.
+	LoadSuccesses int64
+	LoadFailures  int64
+	TotalLoadTime time.Duration
+	MaxLoadTime   time.Duration
+	// LoadTimes counts loads by latency: LoadTimes[n] counts loads that took
+	// at most LoadTimeBuckets[n] and longer than the previous bucket, and
+	// the last element counts loads slower than every bucket.
+	LoadTimes   []int64
+	Evictions   int64
+	Expirations int64
+	// Entries is the current number of entries, loads in flight included.
+	Entries int
+}
+
+// LoadTimeBuckets are the upper bounds of the Stats.LoadTimes histogram.
+var LoadTimeBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type stats struct {
+	hits, misses, coalesced     atomic.Int64
+	loadSuccesses, loadFailures atomic.Int64
+	totalLoadTime, maxLoad      atomic.Int64
+	evictions, expirations      atomic.Int64
+	loadTimes                   [len(LoadTimeBuckets) + 1]atomic.Int64
+}
+
+func (s *stats) loaded(d time.Duration, err error) {
+	if err != nil {
+		s.loadFailures.Add(1)
+	} else {
+		s.loadSuccesses.Add(1)
+	}
+	s.totalLoadTime.Add(int64(d))
+	n := 0
+	for n < len(LoadTimeBuckets) && d > LoadTimeBuckets[n] {
+		n++
+	}
+	s.loadTimes[n].Add(1)
+	for {
+		max := s.maxLoad.Load()
+		if int64(d) <= max || s.maxLoad.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of the cache's counters. The counters are atomics
+// updated on every operation, so they are always on.
+func (tmc *TMCache[K, V]) Stats() Stats {
+	st := Stats{
+		Hits:          tmc.stats.hits.Load(),
+		Misses:        tmc.stats.misses.Load(),
+		Coalesced:     tmc.stats.coalesced.Load(),
+		LoadSuccesses: tmc.stats.loadSuccesses.Load(),
+		LoadFailures:  tmc.stats.loadFailures.Load(),
+		TotalLoadTime: time.Duration(tmc.stats.totalLoadTime.Load()),
+		MaxLoadTime:   time.Duration(tmc.stats.maxLoad.Load()),
+		Evictions:     tmc.stats.evictions.Load(),
+		Expirations:   tmc.stats.expirations.Load(),
+		LoadTimes:     make([]int64, len(LoadTimeBuckets)+1),
+	}
+	for n := range st.LoadTimes {
+		st.LoadTimes[n] = tmc.stats.loadTimes[n].Load()
+	}
+	for _, s := range tmc.shards {
+		s.mu.Lock()
+		st.Entries += s.store.Len() + len(s.items)
+		s.mu.Unlock()
+	}
+	return st
+}
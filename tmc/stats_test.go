@@ -0,0 +1,65 @@
+package tmc
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	release := make(chan struct{})
+	cache := New(func(key string) (string, error) {
+		<-release
+		if key == "bad" {
+			return "", errors.New("bad key")
+		}
+		return key, nil
+	}, hour, WithMaxEntries[string, string](2), WithErrorTTL[string, string](0))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for n := 0; n < 3; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Get("a", hour)
+		}()
+	}
+	for cache.Stats().Misses+cache.Stats().Coalesced < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	cache.Get("a", hour)
+	cache.Get("bad", hour)
+	cache.Get("b", hour)
+	cache.Get("c", hour)
+
+	st := cache.Stats()
+	want := Stats{
+		Hits:          1,
+		Misses:        4,
+		Coalesced:     2,
+		LoadSuccesses: 3,
+		LoadFailures:  1,
+		Evictions:     1,
+		Entries:       2,
+	}
+	if st.TotalLoadTime <= 0 || st.MaxLoadTime <= 0 || st.MaxLoadTime > st.TotalLoadTime {
+		t.Errorf("Error: load times = %v total, %v max", st.TotalLoadTime, st.MaxLoadTime)
+	}
+	var loads int64
+	for _, n := range st.LoadTimes {
+		loads += n
+	}
+	if loads != 4 {
+		t.Errorf("Error: %d loads in LoadTimes; expected 4", loads)
+	}
+	st.TotalLoadTime, st.MaxLoadTime, st.LoadTimes = 0, 0, nil
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("Error: Stats() = %+v; expected %+v", st, want)
+	}
+}
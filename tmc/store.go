@@ -0,0 +1,112 @@
+package tmc
+
+import "time"
+
+// Store keeps the values of one shard of a cache with their expiry metadata,
+// e.g. in memory, on disk or on a remote server. Loads in progress and cached
+// errors stay in the cache, which also does the loading, coalescing and
+// expiry; it serializes all calls to a Store and only hands it finished
+// values, so a Store may copy or serialize the records it is given.
+type Store[K comparable, V any] interface {
+	// Get reports false if key is not stored.
+	Get(key K) (Record[V], bool)
+	Set(key K, r Record[V])
+	// Delete forgets key. Unknown keys are ignored.
+	Delete(key K)
+	// Range calls fn for every stored key until fn returns false. fn does
+	// not call the Store.
+	Range(fn func(key K, r Record[V]) bool)
+	Len() int
+	// Close is called once when the cache is closed, after its keys are
+	// deleted.
+	Close()
+}
+
+// Record is a value kept by a Store with its expiry metadata.
+type Record[V any] struct {
+	Value V
+	// Deadline is when the value stops being fresh, Expires when it is
+	// removed, after the WithStaleWhileRevalidate grace period.
+	Deadline time.Time
+	Expires  time.Time
+	// Idle is the time to idle of the value. Every fresh hit pushes the
+	// deadline to Idle ahead, but not past Limit if it is set.
+	Idle  time.Duration
+	Limit time.Time
+	// Refresh is when a read starts reloading the value ahead of its
+	// deadline, see WithRefreshAhead. It is zero if the value is never
+	// refreshed ahead.
+	Refresh time.Time
+	// Tags are the EntryOptions tags of the value, for DelTag.
+	Tags []string
+}
+
+// record returns the Record of the successfully loaded outcome o.
+func (o *outcome[V]) record() Record[V] {
+	r := Record[V]{
+		Value:    o.res.value,
+		Deadline: time.Unix(0, o.deadline),
+		Expires:  time.Unix(0, o.expires),
+		Idle:     time.Duration(o.idle),
+		Tags:     o.tags,
+	}
+	if o.limit > 0 {
+		r.Limit = time.Unix(0, o.limit)
+	}
+	if o.refreshAt > 0 {
+		r.Refresh = time.Unix(0, o.refreshAt)
+	}
+	return r
+}
+
+// outcome returns the outcome a Record was made from. Its cost is not kept.
+func (r *Record[V]) outcome() outcome[V] {
+	o := outcome[V]{
+		res:      result[V]{value: r.Value},
+		deadline: r.Deadline.UnixNano(),
+		expires:  r.Expires.UnixNano(),
+		idle:     int64(r.Idle),
+		tags:     r.Tags,
+	}
+	if !r.Limit.IsZero() {
+		o.limit = r.Limit.UnixNano()
+	}
+	if !r.Refresh.IsZero() {
+		o.refreshAt = r.Refresh.UnixNano()
+	}
+	return o
+}
+
+type mapStore[K comparable, V any] map[K]Record[V]
+
+// NewMapStore returns the default Store, a plain map.
+func NewMapStore[K comparable, V any]() Store[K, V] {
+	return make(mapStore[K, V])
+}
+
+func (m mapStore[K, V]) Get(key K) (Record[V], bool) {
+	r, ok := m[key]
+	return r, ok
+}
+
+func (m mapStore[K, V]) Set(key K, r Record[V]) {
+	m[key] = r
+}
+
+func (m mapStore[K, V]) Delete(key K) {
+	delete(m, key)
+}
+
+func (m mapStore[K, V]) Range(fn func(key K, r Record[V]) bool) {
+	for k, r := range m {
+		if !fn(k, r) {
+			return
+		}
+	}
+}
+
+func (m mapStore[K, V]) Len() int {
+	return len(m)
+}
+
+func (m mapStore[K, V]) Close() {}
@@ -0,0 +1,144 @@
+// Package storetest checks that a tmc.Store behaves as the cache expects.
+package storetest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sanketitnal/gotmc/tmc"
+)
+
+// Run runs the conformance tests against the stores returned by newStore,
+// both directly and as the store of a cache.
+func Run(t *testing.T, newStore func() tmc.Store[string, string]) {
+	t.Run("GetSet", func(t *testing.T) { testGetSet(t, newStore()) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore()) })
+	t.Run("Range", func(t *testing.T) { testRange(t, newStore()) })
+	t.Run("Cache", func(t *testing.T) { testCache(t, newStore) })
+}
+
+func testGetSet(t *testing.T, s tmc.Store[string, string]) {
+	if r, ok := s.Get("key"); ok || r.Value != "" {
+		t.Errorf("Error: Get of a missing key = %q, %t; expected \"\", false", r.Value, ok)
+	}
+
+	now := time.Now()
+	r := tmc.Record[string]{
+		Value:    "value",
+		Deadline: now.Add(time.Minute),
+		Expires:  now.Add(time.Hour),
+		Idle:     time.Second,
+		Limit:    now.Add(2 * time.Hour),
+		Tags:     []string{"tag"},
+	}
+	s.Set("key", r)
+	if got, ok := s.Get("key"); !ok || !equal(got, r) {
+		t.Errorf("Error: Get = %+v, %t; expected the record set, %+v", got, ok, r)
+	}
+
+	s.Set("key", tmc.Record[string]{Value: "replaced", Expires: now.Add(time.Hour)})
+	if got, _ := s.Get("key"); got.Value != "replaced" {
+		t.Error("Error: Set did not replace the record of a key")
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("Error: Len = %d; expected 1", n)
+	}
+}
+
+// equal compares records by instant, as a Store that serializes them may
+// drop the monotonic clock readings and locations of their times.
+func equal(a, b tmc.Record[string]) bool {
+	return a.Value == b.Value && a.Deadline.Equal(b.Deadline) && a.Expires.Equal(b.Expires) &&
+		a.Idle == b.Idle && a.Limit.Equal(b.Limit) && a.Refresh.Equal(b.Refresh) &&
+		reflect.DeepEqual(a.Tags, b.Tags)
+}
+
+func testDelete(t *testing.T, s tmc.Store[string, string]) {
+	expires := time.Now().Add(time.Hour)
+	s.Set("a", tmc.Record[string]{Value: "a", Expires: expires})
+	s.Set("b", tmc.Record[string]{Value: "b", Expires: expires})
+	s.Delete("a")
+	s.Delete("missing")
+	if _, ok := s.Get("a"); ok {
+		t.Error("Error: deleted key found")
+	}
+	if _, ok := s.Get("b"); !ok {
+		t.Error("Error: Delete removed another key")
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("Error: Len = %d; expected 1", n)
+	}
+}
+
+func testRange(t *testing.T, s tmc.Store[string, string]) {
+	expires := time.Now().Add(time.Hour)
+	keys := []string{"a", "b", "c", "d"}
+	for _, k := range keys {
+		s.Set(k, tmc.Record[string]{Value: k, Expires: expires})
+	}
+
+	seen := map[string]bool{}
+	s.Range(func(key string, r tmc.Record[string]) bool {
+		if r.Value != key {
+			t.Errorf("Error: Range passed %q with the record of %q", key, r.Value)
+		}
+		seen[key] = true
+		return true
+	})
+	if len(seen) != len(keys) {
+		t.Errorf("Error: Range visited %d keys; expected %d", len(seen), len(keys))
+	}
+
+	visits := 0
+	s.Range(func(key string, r tmc.Record[string]) bool {
+		visits++
+		return false
+	})
+	if visits != 1 {
+		t.Errorf("Error: Range went on for %d keys after fn returned false", visits)
+	}
+}
+
+// closing counts the calls of Close on the Store it wraps.
+type closing struct {
+	tmc.Store[string, string]
+	closed *int
+}
+
+func (c closing) Close() {
+	*c.closed++
+	c.Store.Close()
+}
+
+func testCache(t *testing.T, newStore func() tmc.Store[string, string]) {
+	loads, closed := 0, 0
+	cache := tmc.New(func(key string) (string, error) {
+		loads++
+		return "value " + key, nil
+	}, time.Hour, tmc.WithStore[string, string](func() tmc.Store[string, string] {
+		return closing{Store: newStore(), closed: &closed}
+	}), tmc.WithShards[string, string](1))
+
+	cache.Get("a", time.Hour)
+	if val, chit, _ := cache.Get("a", time.Hour); !chit || val != "value a" || loads != 1 {
+		t.Errorf("Error: Get = %q, %t after %d loads; expected a hit after 1 load", val, chit, loads)
+	}
+	cache.Get("short", 10*time.Millisecond)
+	cache.Set("b", "set", time.Hour)
+	cache.Del("a")
+	time.Sleep(20 * time.Millisecond)
+
+	if cache.Has("a") || cache.Has("short") || !cache.Has("b") {
+		t.Errorf("Error: Has a, short, b = %t, %t, %t; expected false, false, true",
+			cache.Has("a"), cache.Has("short"), cache.Has("b"))
+	}
+	if n := cache.Stats().Entries; n != 2 {
+		t.Errorf("Error: %d entries before cleanup; expected 2", n)
+	}
+
+	cache.Close()
+	if closed != 1 {
+		t.Errorf("Error: Close called %d times on the store; expected 1", closed)
+	}
+}
@@ -0,0 +1,55 @@
+package storetest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/sanketitnal/gotmc/tmc"
+)
+
+func TestMapStore(t *testing.T) {
+	Run(t, tmc.NewMapStore[string, string])
+}
+
+// gobStore keeps its records encoded, as a disk or remote store would, so
+// the records it returns are never the ones it was given.
+type gobStore map[string][]byte
+
+func (g gobStore) Get(key string) (tmc.Record[string], bool) {
+	var r tmc.Record[string]
+	data, ok := g[key]
+	if ok {
+		ok = gob.NewDecoder(bytes.NewReader(data)).Decode(&r) == nil
+	}
+	return r, ok
+}
+
+func (g gobStore) Set(key string, r tmc.Record[string]) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err == nil {
+		g[key] = buf.Bytes()
+	}
+}
+
+func (g gobStore) Delete(key string) {
+	delete(g, key)
+}
+
+func (g gobStore) Range(fn func(key string, r tmc.Record[string]) bool) {
+	for k := range g {
+		if r, ok := g.Get(k); ok && !fn(k, r) {
+			return
+		}
+	}
+}
+
+func (g gobStore) Len() int {
+	return len(g)
+}
+
+func (g gobStore) Close() {}
+
+func TestSerializingStore(t *testing.T) {
+	Run(t, func() tmc.Store[string, string] { return make(gobStore) })
+}
@@ -0,0 +1,113 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RemoteStore is a shared second tier cache, such as Redis or memcached,
+// behind a Tiered cache.
+type RemoteStore[K comparable, V any] interface {
+	// Get reports false if key is not stored.
+	Get(ctx context.Context, key K) (V, bool, error)
+	Set(ctx context.Context, key K, value V, ttl time.Duration) error
+	Delete(ctx context.Context, key K) error
+}
+
+// RemoteEraser is implemented by a RemoteStore that can delete every value
+// it stores, as Tiered.EraseAll requires.
+type RemoteEraser interface {
+	EraseAll(ctx context.Context) error
+}
+
+// ErrNoEraseAll is returned by Tiered.EraseAll if its RemoteStore is not a
+// RemoteEraser.
+var ErrNoEraseAll = errors.New("tmc: RemoteStore cannot erase all values")
+
+// Tiered is a TMCache (L1) in front of a RemoteStore (L2). An L1 miss looks
+// in L2, and an L2 miss calls the loader and fills both tiers. Concurrent
+// misses of a key in one process share a single L2 lookup and load. L1 is
+// not exposed, so that every invalidation reaches L2. Values have no tags,
+// as L2 does not keep them, so there is no DelTag.
+type Tiered[K comparable, V any] struct {
+	l1    *TMCache[K, V]
+	l2    RemoteStore[K, V]
+	l2TTL time.Duration
+}
+
+// NewTiered returns a Tiered cache that stores loaded values in l2 for l2TTL.
+// L1 is built from cleanupTimeout and opts as by NewContext, and keeps values
+// for the ttl passed to Get. Failures to read or write l2 do not fail a Get:
+// the value is loaded, or served, without it.
+func NewTiered[K comparable, V any](loader ContextLoader[K, V], l2 RemoteStore[K, V], l2TTL time.Duration, cleanupTimeout time.Duration, opts ...Option[K, V]) *Tiered[K, V] {
+	t := &Tiered[K, V]{l2: l2, l2TTL: l2TTL}
+	t.l1 = NewContext(func(ctx context.Context, key K) (V, error) {
+		if value, ok, err := l2.Get(ctx, key); err == nil && ok {
+			return value, nil
+		}
+		value, err := loader(ctx, key)
+		if err == nil {
+			l2.Set(ctx, key, value, l2TTL)
+		}
+		return value, err
+	}, cleanupTimeout, opts...)
+	return t
+}
+
+// Stats returns the statistics of L1, so a Tiered cache can be exported
+// like a TMCache.
+func (t *Tiered[K, V]) Stats() Stats {
+	return t.l1.Stats()
+}
+
+// Peek is like TMCache.Peek on L1. It does not look in L2.
+func (t *Tiered[K, V]) Peek(key K) (V, bool) {
+	return t.l1.Peek(key)
+}
+
+// Has reports whether Peek would find key.
+func (t *Tiered[K, V]) Has(key K) bool {
+	return t.l1.Has(key)
+}
+
+func (t *Tiered[K, V]) Get(key K, ttl time.Duration) (V, bool, error) {
+	return t.l1.Get(key, ttl)
+}
+
+// GetContext is like TMCache.GetContext. The hit result is only true for
+// L1 hits.
+func (t *Tiered[K, V]) GetContext(ctx context.Context, key K, ttl time.Duration) (V, bool, error) {
+	return t.l1.GetContext(ctx, key, ttl)
+}
+
+// Set stores value in L1 for ttl and in L2 for the L2 TTL.
+func (t *Tiered[K, V]) Set(ctx context.Context, key K, value V, ttl time.Duration) error {
+	t.l1.Set(key, value, ttl)
+	return t.l2.Set(ctx, key, value, t.l2TTL)
+}
+
+// Del deletes key from both tiers, L2 first so that L1 is not refilled from
+// it. Other processes keep their L1 copy until it expires.
+func (t *Tiered[K, V]) Del(ctx context.Context, key K) error {
+	err := t.l2.Delete(ctx, key)
+	t.l1.Del(key)
+	return err
+}
+
+// EraseAll deletes every value from both tiers, L2 first. It returns
+// ErrNoEraseAll, and only erases L1, if L2 is not a RemoteEraser. Other
+// processes keep their L1 copies until they expire.
+func (t *Tiered[K, V]) EraseAll(ctx context.Context) error {
+	err := ErrNoEraseAll
+	if e, ok := t.l2.(RemoteEraser); ok {
+		err = e.EraseAll(ctx)
+	}
+	t.l1.EraseAll()
+	return err
+}
+
+// Close closes L1. L2 is left to its owner.
+func (t *Tiered[K, V]) Close() {
+	t.l1.Close()
+}
@@ -0,0 +1,141 @@
+package tmc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemote is an in-memory RemoteStore.
+type fakeRemote struct {
+	mu     sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+	err    error
+}
+
+func newFakeRemote() *fakeRemote {
+	return &fakeRemote{values: map[string]string{}, ttls: map[string]time.Duration{}}
+}
+
+func (f *fakeRemote) Get(ctx context.Context, key string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return "", false, f.err
+	}
+	value, ok := f.values[key]
+	return value, ok, nil
+}
+
+func (f *fakeRemote) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.values[key] = value
+	f.ttls[key] = ttl
+	return nil
+}
+
+func (f *fakeRemote) Delete(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.values, key)
+	return f.err
+}
+
+// erasingRemote is a fakeRemote that is a RemoteEraser.
+type erasingRemote struct {
+	*fakeRemote
+}
+
+func (e erasingRemote) EraseAll(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = map[string]string{}
+	return e.err
+}
+
+func TestTieredFillsBothTiers(t *testing.T) {
+	remote := newFakeRemote()
+	loads := 0
+	loader := func(ctx context.Context, key string) (string, error) {
+		loads++
+		return "value " + key, nil
+	}
+	a := NewTiered[string, string](loader, remote, hour, hour)
+	defer a.Close()
+	b := NewTiered[string, string](loader, remote, hour, hour)
+	defer b.Close()
+
+	if val, chit, err := a.Get("key", minute); val != "value key" || chit || err != nil {
+		t.Errorf("Error: Get = %q, %t, %v; expected a loaded value", val, chit, err)
+	}
+	if remote.values["key"] != "value key" || remote.ttls["key"] != hour {
+		t.Errorf("Error: L2 holds %q for %v; expected the loaded value for an hour", remote.values["key"], remote.ttls["key"])
+	}
+	if val, _, _ := b.Get("key", minute); val != "value key" || loads != 1 {
+		t.Errorf("Error: second L1 got %q after %d loads; expected the L2 value after 1 load", val, loads)
+	}
+	if _, chit, _ := a.Get("key", minute); !chit {
+		t.Error("Error: L1 miss after the value was loaded")
+	}
+
+	if err := a.Del(context.Background(), "key"); err != nil {
+		t.Errorf("Error: Del: %v", err)
+	}
+	if a.Has("key") || remote.values["key"] != "" {
+		t.Error("Error: Del left the key in a tier")
+	}
+}
+
+func TestTieredRemoteErrors(t *testing.T) {
+	remote := newFakeRemote()
+	remote.err = errors.New("unreachable")
+	cache := NewTiered[string, string](func(ctx context.Context, key string) (string, error) {
+		return "value", nil
+	}, remote, hour, hour)
+	defer cache.Close()
+
+	if val, _, err := cache.Get("key", minute); val != "value" || err != nil {
+		t.Errorf("Error: Get with L2 down = %q, %v; expected the loaded value", val, err)
+	}
+	if err := cache.Set(context.Background(), "set", "value", minute); err == nil {
+		t.Error("Error: Set did not report the L2 error")
+	}
+	if !cache.Has("set") {
+		t.Error("Error: Set with L2 down did not fill L1")
+	}
+}
+
+func TestTieredEraseAll(t *testing.T) {
+	loader := func(ctx context.Context, key string) (string, error) {
+		return "value " + key, nil
+	}
+	remote := erasingRemote{newFakeRemote()}
+	cache := NewTiered[string, string](loader, remote, hour, hour)
+	defer cache.Close()
+
+	cache.Get("a", minute)
+	cache.Get("b", minute)
+	if err := cache.EraseAll(context.Background()); err != nil {
+		t.Errorf("Error: EraseAll: %v", err)
+	}
+	if cache.Has("a") || len(remote.values) != 0 {
+		t.Error("Error: EraseAll left values in a tier")
+	}
+	if n := cache.Stats().Entries; n != 0 {
+		t.Errorf("Error: %d entries in L1 after EraseAll; expected 0", n)
+	}
+
+	plain := NewTiered[string, string](loader, newFakeRemote(), hour, hour)
+	defer plain.Close()
+	plain.Get("a", minute)
+	if err := plain.EraseAll(context.Background()); err != ErrNoEraseAll || plain.Has("a") {
+		t.Errorf("Error: EraseAll without a RemoteEraser = %v; expected ErrNoEraseAll and L1 erased", err)
+	}
+}
@@ -0,0 +1,196 @@
+package tmc
+
+import "container/list"
+
+const (
+	inWindow = iota
+	inProbation
+	inProtected
+)
+
+type tinyLFUEntry[K comparable] struct {
+	key     K
+	segment int
+}
+
+// tinyLFU is a W-TinyLFU policy: new keys enter a small LRU window, and a key
+// leaving the window is admitted to the segmented main LRU only if the
+// frequency sketch estimates it to be more popular than the main victim.
+type tinyLFU[K comparable] struct {
+	sketch *cmSketch
+	hash   func(K) uint64
+	elems  map[K]*list.Element
+
+	window, probation, protected     *list.List
+	windowCap, mainCap, protectedCap int
+}
+
+// NewTinyLFU returns a W-TinyLFU Policy that keeps at most capacity keys. It
+// resists scans: a one-off sweep over many keys does not flush keys that are
+// read often.
+func NewTinyLFU[K comparable](capacity int) Policy[K] {
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	if mainCap < 0 {
+		mainCap = 0
+	}
+	return &tinyLFU[K]{
+		sketch:       newCMSketch(capacity),
+		hash:         newHasher[K](),
+		elems:        make(map[K]*list.Element),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * 8 / 10,
+	}
+}
+
+func (p *tinyLFU[K]) Add(key K) []K {
+	p.sketch.increment(p.hash(key))
+	if _, ok := p.elems[key]; ok {
+		p.Access(key)
+		return nil
+	}
+	p.elems[key] = p.window.PushFront(&tinyLFUEntry[K]{key: key, segment: inWindow})
+	if p.window.Len() <= p.windowCap {
+		return nil
+	}
+
+	candidate := p.window.Remove(p.window.Back()).(*tinyLFUEntry[K])
+	delete(p.elems, candidate.key)
+	if p.probation.Len()+p.protected.Len() < p.mainCap {
+		p.push(p.probation, candidate, inProbation)
+		return nil
+	}
+
+	victims := p.probation
+	if victims.Len() == 0 {
+		victims = p.protected
+	}
+	if victims.Len() == 0 {
+		return []K{candidate.key}
+	}
+	victim := victims.Back().Value.(*tinyLFUEntry[K])
+	if p.sketch.estimate(p.hash(candidate.key)) <= p.sketch.estimate(p.hash(victim.key)) {
+		return []K{candidate.key}
+	}
+	p.Remove(victim.key)
+	p.push(p.probation, candidate, inProbation)
+	return []K{victim.key}
+}
+
+func (p *tinyLFU[K]) Access(key K) {
+	p.sketch.increment(p.hash(key))
+	e, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*tinyLFUEntry[K])
+	switch entry.segment {
+	case inWindow:
+		p.window.MoveToFront(e)
+	case inProtected:
+		p.protected.MoveToFront(e)
+	case inProbation:
+		p.probation.Remove(e)
+		p.push(p.protected, entry, inProtected)
+		if p.protected.Len() > p.protectedCap {
+			demoted := p.protected.Remove(p.protected.Back()).(*tinyLFUEntry[K])
+			p.push(p.probation, demoted, inProbation)
+		}
+	}
+}
+
+func (p *tinyLFU[K]) Remove(key K) {
+	e, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	delete(p.elems, key)
+	switch e.Value.(*tinyLFUEntry[K]).segment {
+	case inWindow:
+		p.window.Remove(e)
+	case inProbation:
+		p.probation.Remove(e)
+	case inProtected:
+		p.protected.Remove(e)
+	}
+}
+
+func (p *tinyLFU[K]) Victim() (K, bool) {
+	for _, l := range []*list.List{p.probation, p.protected, p.window} {
+		if e := l.Back(); e != nil {
+			key := e.Value.(*tinyLFUEntry[K]).key
+			p.Remove(key)
+			return key, true
+		}
+	}
+	var zero K
+	return zero, false
+}
+
+func (p *tinyLFU[K]) push(l *list.List, entry *tinyLFUEntry[K], segment int) {
+	entry.segment = segment
+	p.elems[entry.key] = l.PushFront(entry)
+}
+
+// cmSketch is a count-min sketch of 4 bit saturating counters. All counters
+// are halved once the number of increments reaches ten times the cache
+// capacity, so old popularity fades.
+type cmSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+var sketchSeeds = [4]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+func newCMSketch(capacity int) *cmSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &cmSketch{mask: uint64(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		c := &s.rows[i][mix(h^sketchSeeds[i])&s.mask]
+		if *c < 15 {
+			*c++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *cmSketch) estimate(h uint64) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][mix(h^sketchSeeds[i])&s.mask]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,43 @@
+package tmc
+
+import "testing"
+
+func TestTinyLFUResistsScans(t *testing.T) {
+	cache := New(func(key int) (int, error) {
+		return key, nil
+	}, hour, WithPolicy[int, int](NewTinyLFU[int](100)))
+	defer cache.Close()
+
+	for n := 0; n < 20; n++ {
+		for key := 0; key < 50; key++ {
+			cache.Get(key, hour)
+		}
+	}
+	for key := 1000; key < 11000; key++ {
+		cache.Get(key, hour)
+	}
+
+	hits := 0
+	for key := 0; key < 50; key++ {
+		if _, chit, _ := cache.Get(key, hour); chit {
+			hits++
+		}
+	}
+	if hits < 45 {
+		t.Errorf("Error: %d of 50 hot keys survived a scan; expected at least 45", hits)
+	}
+}
+
+func TestTinyLFUBoundsEntries(t *testing.T) {
+	p := NewTinyLFU[int](10)
+	cached := make(map[int]bool)
+	for key := 0; key < 1000; key++ {
+		cached[key] = true
+		for _, k := range p.Add(key) {
+			delete(cached, k)
+		}
+	}
+	if len(cached) != 10 {
+		t.Errorf("Error: %d keys cached; expected 10", len(cached))
+	}
+}
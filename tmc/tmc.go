@@ -1,37 +1,215 @@
 package tmc
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Loader computes the value for key on a cache miss.
+type Loader[K comparable, V any] func(key K) (V, error)
+
+// ContextLoader is a Loader that observes ctx. ctx carries the values of the
+// context of the read that started the load, and is cancelled once every
+// caller waiting for the key has given up.
+type ContextLoader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// EntryLoader is a ContextLoader that also returns options for the entry it
+// loaded, e.g. a TTL taken from an HTTP max-age.
+type EntryLoader[K comparable, V any] func(ctx context.Context, key K) (V, EntryOptions, error)
+
+// EntryOptions are per entry settings returned by an EntryLoader. Zero fields
+// keep the cache's defaults.
+type EntryOptions struct {
+	// TTL overrides the ttl passed to Get for a successfully loaded value.
+	TTL time.Duration
+	// Cost overrides the WithMaxCost cost function.
+	Cost int64
+	// Tags label the entry for DelTag.
+	Tags []string
+	// TimeToIdle and MaxAge override the WithTimeToIdle settings.
+	TimeToIdle time.Duration
+	MaxAge     time.Duration
+	// NoCache hands the result to the waiting callers without caching it.
+	NoCache bool
+}
+
+// Func is the loader type of the string keyed, untyped cache returned by NewTMCache.
 type Func func(key string) (interface{}, error)
 
-type TMCache struct {
-	done  chan struct{}
-	mu    sync.Mutex
-	items map[string]*item
-	f     Func
+type TMCache[K comparable, V any] struct {
+	done    chan struct{}
+	nshards int
+	shards  []*shard[K, V]
+	hash    func(key K) uint64
+	f       EntryLoader[K, V]
+	batch   BatchLoader[K, V]
+
+	errTTL       *time.Duration
+	permanent    func(err error) bool
+	staleGrace   time.Duration
+	refreshAhead float64
+	idle         time.Duration
+	maxAge       time.Duration
+	jitter       float64
+	random       func() float64
+	codec        Codec
+	newStore     func() Store[K, V]
+	disk         *disk[K]
+	onRemove     func(key K, value V, reason RemovalReason)
+	stats        stats
+	observer     Observer[K]
+
+	// policyMu guards the eviction state below. It may be acquired while a
+	// shard lock is held, never the other way around.
+	policyMu  sync.Mutex
+	policy    Policy[K]
+	hits      []access[K] // scratch space of drain
+	tracked   map[K]int64 // cost of every key handed to the policy
+	maxCost   int64
+	cost      func(value V) int64
+	totalCost int64
+
+	// accessSeq numbers the hits buffered by the shards, so they reach the
+	// policy in the order they happened.
+	accessSeq atomic.Uint64
 }
 
-type item struct {
-	done     chan struct{}
-	deadline int64
-	res      result
+// shard holds the entries of the keys that hash to it. A key is either
+// loading or cached as an error in items, or stored in store, never both.
+type shard[K comparable, V any] struct {
+	mu         sync.Mutex
+	items      map[K]*item[V]
+	store      Store[K, V]
+	expiry     expiry[K]
+	refreshing map[K]bool
+	removed    []removal[K, V]
+	spills     []spillWrite[K, V]
+
+	// accesses buffers hits for the eviction policy. accessMu is only ever
+	// held on its own or inside policyMu.
+	accessMu sync.Mutex
+	accesses []access[K]
+}
+
+// access is a hit buffered for the eviction policy, numbered by accessSeq.
+type access[K comparable] struct {
+	key K
+	seq uint64
 }
 
-type result struct {
-	value interface{}
+type removal[K comparable, V any] struct {
+	key    K
+	value  V
+	reason RemovalReason
+}
+
+const defaultShards = 16
+
+// accessBatch is the number of hits a shard buffers before it hands them to
+// the eviction policy.
+const accessBatch = 64
+
+// item is a load in flight or, once it failed with an error that is cached,
+// that error. Loaded values are kept in the shard's store.
+type item[V any] struct {
+	outcome[V]
+	done    chan struct{}
+	ttl     time.Duration
+	loading bool
+	waiters int
+	cancel  func()
+}
+
+// outcome is the cached state of a finished load.
+type outcome[V any] struct {
+	res       result[V]
+	deadline  int64 // fresh until
+	expires   int64 // removed at, after the stale grace period
+	refreshAt int64 // reloaded ahead of its deadline by a read after this
+	cost      int64
+	tags      []string
+	idle      int64  // time to idle, slides deadline on hits
+	limit     int64  // deadline cap of an idle entry, 0 if none
+	spill     []byte // encoding of a value kept on disk only, see measure
+	restored  bool   // read back from disk
+	stale     bool   // read back from disk past its deadline
+}
+
+type result[V any] struct {
+	value V
 	err   error
 }
 
-func NewTMCache(fun Func, cleanupTimeout time.Duration) *TMCache {
-	tmc := &TMCache{
-		done:  make(chan struct{}),
-		items: make(map[string]*item),
-		f:     fun,
+// PanicError is returned to every caller waiting on a load whose loader
+// panicked. The entry is not cached, so the next Get retries the load. Stack
+// is the stack of the panic; it is not part of the message.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("tmc: loader panicked: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// New returns a cache that memoizes loader. Expired keys are removed every cleanupTimeout.
+func New[K comparable, V any](loader Loader[K, V], cleanupTimeout time.Duration, opts ...Option[K, V]) *TMCache[K, V] {
+	return NewContext(func(_ context.Context, key K) (V, error) {
+		return loader(key)
+	}, cleanupTimeout, opts...)
+}
+
+// NewContext is like New for a loader that accepts a context.
+func NewContext[K comparable, V any](loader ContextLoader[K, V], cleanupTimeout time.Duration, opts ...Option[K, V]) *TMCache[K, V] {
+	return NewEntry(func(ctx context.Context, key K) (V, EntryOptions, error) {
+		value, err := loader(ctx, key)
+		return value, EntryOptions{}, err
+	}, cleanupTimeout, opts...)
+}
+
+// NewEntry is like NewContext for a loader that also sets entry options.
+func NewEntry[K comparable, V any](loader EntryLoader[K, V], cleanupTimeout time.Duration, opts ...Option[K, V]) *TMCache[K, V] {
+	tmc := &TMCache[K, V]{
+		done:     make(chan struct{}),
+		nshards:  defaultShards,
+		f:        loader,
+		observer: NopObserver[K]{},
+		codec:    GobCodec,
+		newStore: NewMapStore[K, V],
+	}
+	for _, opt := range opts {
+		opt(tmc)
+	}
+	if tmc.nshards < 1 {
+		tmc.nshards = 1
+	}
+	tmc.shards = make([]*shard[K, V], tmc.nshards)
+	for n := range tmc.shards {
+		tmc.shards[n] = &shard[K, V]{
+			items: make(map[K]*item[V]),
+			store: tmc.newStore(),
+		}
+	}
+	if tmc.nshards > 1 && tmc.hash == nil {
+		tmc.hash = newHasher[K]()
+	}
+	if tmc.maxCost > 0 && tmc.policy == nil {
+		tmc.policy = NewLRU[K](0)
+	}
+	if tmc.policy != nil {
+		tmc.tracked = make(map[K]int64)
 	}
 
 	go cleanup(tmc, cleanupTimeout)
@@ -39,21 +217,26 @@ func NewTMCache(fun Func, cleanupTimeout time.Duration) *TMCache {
 	return tmc
 }
 
-func (tmc *TMCache) routineCleanup() {
-	now := time.Now().UnixNano()
+// NewTMCache returns a string keyed cache of untyped values. It is kept for
+// compatibility; new code should prefer New.
+func NewTMCache(fun Func, cleanupTimeout time.Duration, opts ...Option[string, any]) *TMCache[string, any] {
+	return New(Loader[string, any](fun), cleanupTimeout, opts...)
+}
 
-	tmc.mu.Lock()
+func (tmc *TMCache[K, V]) routineCleanup() {
+	for _, s := range tmc.shards {
+		now := time.Now().UnixNano()
 
-	for k, i := range tmc.items {
-		if i.deadline <= now {
-			delete(tmc.items, k)
-		}
+		s.mu.Lock()
+		tmc.expire(s, now)
+		tmc.unlock(s)
+	}
+	if tmc.disk != nil {
+		tmc.disk.expire(time.Now().UnixNano())
 	}
-
-	tmc.mu.Unlock()
 }
 
-func cleanup(tmc *TMCache, cleanupTimeout time.Duration) {
+func cleanup[K comparable, V any](tmc *TMCache[K, V], cleanupTimeout time.Duration) {
 	ticker := time.NewTicker(cleanupTimeout)
 
 	for {
@@ -67,49 +250,743 @@ func cleanup(tmc *TMCache, cleanupTimeout time.Duration) {
 	}
 }
 
-func (tmc *TMCache) Get(key string, ttl time.Duration) (interface{}, bool, error) {
-	chit := false
-	tmc.mu.Lock()
-	i := tmc.items[key]
+func (tmc *TMCache[K, V]) Get(key K, ttl time.Duration) (V, bool, error) {
+	return tmc.GetContext(context.Background(), key, ttl)
+}
+
+// GetContext is like Get but stops waiting for the value when ctx is done, in
+// which case ctx.Err() is returned.
+func (tmc *TMCache[K, V]) GetContext(ctx context.Context, key K, ttl time.Duration) (V, bool, error) {
+	r, err := tmc.Fetch(ctx, key, ttl)
+	return r.Value, r.Hit, err
+}
+
+// Result describes where a value returned by Fetch came from.
+type Result[V any] struct {
+	Value V
+	// Hit is true if the value was cached, in memory or on disk, or already
+	// being loaded.
+	Hit bool
+	// Stale is true if the value is past its TTL and served during the
+	// WithStaleWhileRevalidate grace period while a reload runs.
+	Stale bool
+}
+
+// Fetch is like GetContext but reports whether the value was stale.
+func (tmc *TMCache[K, V]) Fetch(ctx context.Context, key K, ttl time.Duration) (Result[V], error) {
+	start := time.Now()
+	ctx = tmc.observer.LookupStart(ctx, key)
+	r, err := tmc.fetch(ctx, key, ttl)
+	tmc.observer.LookupEnd(ctx, key, r.Hit, time.Since(start), err)
+	return r, err
+}
+
+func (tmc *TMCache[K, V]) fetch(ctx context.Context, key K, ttl time.Duration) (Result[V], error) {
+	s := tmc.shard(key)
+	s.mu.Lock()
+	i, r, err, created := tmc.lookup(s, key, ttl)
+	if created {
+		lctx, cancel := context.WithCancel(detached{ctx})
+		i.cancel = cancel
+		go tmc.load(lctx, s, key, i)
+	}
+	tmc.unlock(s)
+
 	if i == nil {
-		i = &item{
-			deadline: time.Now().UnixNano() + int64(ttl),
-			done:     make(chan struct{}),
+		tmc.accessed(key)
+		return r, err
+	}
+	if r.Hit {
+		tmc.observer.CoalescedWait(ctx, key)
+	}
+
+	select {
+	case <-i.done:
+		r.Value, r.Stale = i.res.value, i.stale
+		r.Hit = r.Hit || i.restored
+		return r, i.res.err
+	case <-ctx.Done():
+		tmc.abandon(s, key, i)
+		return r, ctx.Err()
+	}
+}
+
+// lookup counts a read of key. On a miss it creates an in-flight item, which
+// the caller must start loading; the read is counted once it is known whether
+// the value is on disk. If key is still loading the caller is added
+// to the waiters of its item. Otherwise i is nil and the read is a hit
+// answered by r and err, which may start a background reload. The caller
+// holds s.mu.
+func (tmc *TMCache[K, V]) lookup(s *shard[K, V], key K, ttl time.Duration) (i *item[V], r Result[V], err error, created bool) {
+	now := time.Now().UnixNano()
+	if i = s.items[key]; i != nil {
+		if i.loading {
+			tmc.stats.coalesced.Add(1)
+			i.waiters++
+			r.Hit = true
+			return i, r, nil, false
+		}
+		if !i.expired(now) {
+			tmc.stats.hits.Add(1)
+			r.Hit = true
+			return nil, r, i.res.err, false
+		}
+		tmc.forget(s, key, i, Expired)
+	} else if rec, ok := s.store.Get(key); ok {
+		if rec.Expires.UnixNano() > now {
+			tmc.stats.hits.Add(1)
+			return nil, tmc.hit(s, key, rec, ttl, now), nil, false
+		}
+		tmc.remove(s, key, rec, Expired)
+	}
+
+	i = &item[V]{
+		ttl:     ttl,
+		done:    make(chan struct{}),
+		loading: true,
+		waiters: 1,
+		cancel:  func() {},
+	}
+	s.items[key] = i
+	return i, r, nil, true
+}
+
+// hit answers a read of the stored record rec of key. It slides the deadline
+// of an idle record, and reloads a stale one, or one due for refresh-ahead,
+// in the background. The caller holds s.mu.
+func (tmc *TMCache[K, V]) hit(s *shard[K, V], key K, rec Record[V], ttl time.Duration, now int64) Result[V] {
+	r := Result[V]{Value: rec.Value, Hit: true}
+	deadline := rec.Deadline.UnixNano()
+	r.Stale = deadline <= now
+	switch {
+	case r.Stale:
+		tmc.refresh(s, key, rec.Expires, ttl)
+	case rec.Idle > 0:
+		var limit int64
+		if !rec.Limit.IsZero() {
+			limit = rec.Limit.UnixNano()
+		}
+		d := slide(now, int64(rec.Idle), limit)
+		rec.Expires = rec.Expires.Add(time.Duration(d - deadline))
+		rec.Deadline = time.Unix(0, d)
+		s.store.Set(key, rec)
+	case !rec.Refresh.IsZero() && rec.Refresh.UnixNano() <= now:
+		tmc.refresh(s, key, rec.Expires, ttl)
+	}
+	return r
+}
+
+// accessed reports a hit on key to the eviction policy. Hits are buffered per
+// shard and handed over in batches, so reads of a bounded cache only take
+// policyMu once every accessBatch hits of a shard.
+func (tmc *TMCache[K, V]) accessed(key K) {
+	if tmc.policy == nil {
+		return
+	}
+	s := tmc.shard(key)
+	s.accessMu.Lock()
+	s.accesses = append(s.accesses, access[K]{key: key, seq: tmc.accessSeq.Add(1)})
+	full := len(s.accesses) >= accessBatch
+	s.accessMu.Unlock()
+	if full {
+		tmc.policyMu.Lock()
+		tmc.drain()
+		tmc.policyMu.Unlock()
+	}
+}
+
+// drain hands the hits buffered by every shard to the policy, in the order
+// they happened. The caller holds policyMu.
+func (tmc *TMCache[K, V]) drain() {
+	hits := tmc.hits[:0]
+	for _, s := range tmc.shards {
+		s.accessMu.Lock()
+		hits = append(hits, s.accesses...)
+		s.accesses = s.accesses[:0]
+		s.accessMu.Unlock()
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].seq < hits[j].seq })
+	for _, h := range hits {
+		tmc.policy.Access(h.key)
+	}
+	tmc.hits = hits[:0]
+}
+
+type entry[K comparable, V any] struct {
+	key    K
+	record Record[V]
+}
+
+// records returns the records stored in s, so they can be removed while
+// iterating. The caller holds s.mu.
+func (s *shard[K, V]) records() []entry[K, V] {
+	var entries []entry[K, V]
+	s.store.Range(func(key K, r Record[V]) bool {
+		entries = append(entries, entry[K, V]{key: key, record: r})
+		return true
+	})
+	return entries
+}
+
+func (tmc *TMCache[K, V]) shard(key K) *shard[K, V] {
+	if len(tmc.shards) == 1 {
+		return tmc.shards[0]
+	}
+	return tmc.shards[tmc.hash(key)%uint64(len(tmc.shards))]
+}
+
+func (tmc *TMCache[K, V]) load(ctx context.Context, s *shard[K, V], key K, i *item[V]) {
+	if tmc.restore(s, key, i) {
+		return
+	}
+	tmc.stats.misses.Add(1)
+	value, eo, err := tmc.call(ctx, key)
+	tmc.finish(s, key, i, value, eo, err)
+}
+
+// finish stores the outcome of loading key into the in-flight item i and
+// wakes its waiters.
+func (tmc *TMCache[K, V]) finish(s *shard[K, V], key K, i *item[V], value V, eo EntryOptions, err error) {
+	o, keep := tmc.settle(i.ttl, value, eo, err)
+	s.mu.Lock()
+	tmc.complete(s, key, i, o, keep)
+}
+
+// complete settles the in-flight item i with o, caching it if keep, and
+// wakes its waiters. The caller holds s.mu, which complete releases. If Set
+// has settled i already, o is dropped.
+func (tmc *TMCache[K, V]) complete(s *shard[K, V], key K, i *item[V], o outcome[V], keep bool) {
+	if !i.loading {
+		tmc.unlock(s)
+		return
+	}
+
+	var evicted []K
+	i.cancel()
+	i.outcome = o
+	i.loading = false
+	if s.items[key] == i {
+		switch {
+		case !keep:
+			delete(s.items, key)
+		case o.res.err != nil:
+			s.expiry.schedule(key, o.expires)
+			evicted = tmc.admit(key, o.cost)
+		default:
+			delete(s.items, key)
+			evicted = tmc.cache(s, key, o)
+		}
+	}
+	var d deferred[K, V]
+	tmc.release(s, &d)
+	tmc.evict(evicted, &d)
+
+	// The waiters do not wait on disk writes or callbacks.
+	close(i.done)
+	tmc.run(d)
+}
+
+// refresh starts reloading the value of key that expires at expires in the
+// background unless a reload is already running. The caller holds s.mu.
+func (tmc *TMCache[K, V]) refresh(s *shard[K, V], key K, expires time.Time, ttl time.Duration) {
+	if s.refreshing[key] {
+		return
+	}
+	if s.refreshing == nil {
+		s.refreshing = make(map[K]bool)
+	}
+	s.refreshing[key] = true
+	go tmc.reload(s, key, expires, ttl)
+}
+
+// reload replaces the value of key that expires at expires, in memory or on
+// disk, with a freshly loaded value. If the load fails, or the value changed
+// in the meantime, it stays in place until it expires and the next read
+// retries.
+func (tmc *TMCache[K, V]) reload(s *shard[K, V], key K, expires time.Time, ttl time.Duration) {
+	value, eo, err := tmc.call(context.Background(), key)
+	o, keep := tmc.settle(ttl, value, eo, err)
+
+	var evicted []K
+	s.mu.Lock()
+	delete(s.refreshing, key)
+	if err == nil && keep {
+		if rec, ok := s.store.Get(key); ok && rec.Expires.Equal(expires) {
+			tmc.remove(s, key, rec, Replaced)
+			evicted = tmc.cache(s, key, o)
+		} else if !ok && s.items[key] == nil && tmc.disk != nil && tmc.disk.expiring(key, expires.UnixNano()) {
+			evicted = tmc.cache(s, key, o)
+		}
+	}
+	var d deferred[K, V]
+	tmc.release(s, &d)
+	tmc.evict(evicted, &d)
+	tmc.run(d)
+}
+
+// settle returns the cached state for a load of ttl that returned value, eo
+// and err, and whether it may be cached at all.
+func (tmc *TMCache[K, V]) settle(ttl time.Duration, value V, eo EntryOptions, err error) (outcome[V], bool) {
+	keep := !eo.NoCache
+	if err != nil {
+		var cacheErr bool
+		ttl, cacheErr = tmc.errorTTL(ttl, err)
+		keep = keep && cacheErr
+	} else if eo.TTL > 0 {
+		ttl = eo.TTL
+	}
+	o := outcome[V]{res: result[V]{value: value, err: err}, tags: eo.Tags}
+	keep = tmc.weigh(&o, eo.Cost) && keep
+	if tmc.jitter > 0 {
+		ttl += time.Duration(tmc.jitter * (2*tmc.random() - 1) * float64(ttl))
+	}
+	now := time.Now().UnixNano()
+	o.deadline = now + int64(ttl)
+	if idle, maxAge := tmc.idleTTL(eo); err == nil && idle > 0 {
+		o.idle = int64(idle)
+		if maxAge > 0 {
+			o.limit = now + int64(maxAge)
+		}
+		o.deadline = slide(now, o.idle, o.limit)
+	}
+	o.expires = o.deadline
+	if err == nil {
+		o.expires += int64(tmc.staleGrace)
+		// An idle entry has no fixed TTL to refresh ahead of.
+		if tmc.refreshAhead > 0 && o.idle == 0 {
+			o.refreshAt = now + int64(tmc.refreshAhead*float64(ttl))
+		}
+	}
+	if keep {
+		tmc.measure(&o)
+	}
+	return o, keep
+}
+
+// weigh sets the WithMaxCost cost of o, which is cost if it is > 0, and
+// reports whether o fits the budget.
+func (tmc *TMCache[K, V]) weigh(o *outcome[V], cost int64) bool {
+	if tmc.maxCost <= 0 {
+		return true
+	}
+	switch {
+	case o.res.err != nil:
+		o.cost = 1 // a cached error holds no value
+	case cost > 0:
+		o.cost = cost
+	default:
+		o.cost = tmc.cost(o.res.value)
+	}
+	return o.cost <= tmc.maxCost
+}
+
+// idleTTL returns the time to idle and max age of an entry loaded with eo.
+func (tmc *TMCache[K, V]) idleTTL(eo EntryOptions) (idle, maxAge time.Duration) {
+	idle, maxAge = tmc.idle, tmc.maxAge
+	if eo.TimeToIdle > 0 {
+		idle = eo.TimeToIdle
+	}
+	if eo.MaxAge > 0 {
+		maxAge = eo.MaxAge
+	}
+	return idle, maxAge
+}
+
+// slide returns the deadline of an entry with time to idle idle, capped at
+// limit if limit > 0, read at now.
+func slide(now, idle, limit int64) int64 {
+	d := now + idle
+	if limit > 0 && limit < d {
+		d = limit
+	}
+	return d
+}
+
+// errorTTL reports how long the loader error err is cached for, and whether it
+// is cached at all. Panics are never cached.
+func (tmc *TMCache[K, V]) errorTTL(ttl time.Duration, err error) (time.Duration, bool) {
+	if _, ok := err.(*PanicError); ok {
+		return 0, false
+	}
+	if tmc.permanent != nil && !tmc.permanent(err) {
+		return 0, false
+	}
+	if tmc.errTTL != nil {
+		return *tmc.errTTL, *tmc.errTTL > 0
+	}
+	return ttl, true
+}
+
+// expired reports whether i is a cached error that may no longer be served.
+// The TTL starts when the load completes, so in-flight items never expire.
+func (i *item[V]) expired(now int64) bool {
+	return !i.loading && i.expires <= now
+}
+
+func (tmc *TMCache[K, V]) call(ctx context.Context, key K) (value V, eo EntryOptions, err error) {
+	start := time.Now()
+	ctx = tmc.observer.LoadStart(ctx, key)
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
 		}
-		tmc.items[key] = i
-		tmc.mu.Unlock()
+		d := time.Since(start)
+		tmc.stats.loaded(d, err)
+		tmc.observer.LoadEnd(ctx, key, d, err)
+	}()
+	return tmc.f(ctx, key)
+}
+
+// detached passes the values of a caller's context on to a load, but not its
+// cancellation: the load outlives the caller once other callers wait on it.
+type detached struct {
+	context.Context
+}
 
-		i.res.value, i.res.err = tmc.f(key)
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
 
-		close(i.done)
-	} else {
-		chit = true
-		tmc.mu.Unlock()
-		<-i.done
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// abandon drops a waiter of i. When the last waiter of an in-flight load
+// leaves, the load is cancelled and forgotten so the next Get starts afresh.
+func (tmc *TMCache[K, V]) abandon(s *shard[K, V], key K, i *item[V]) {
+	s.mu.Lock()
+	if i.loading {
+		i.waiters--
+		if i.waiters == 0 {
+			i.cancel()
+			tmc.forget(s, key, i, 0)
+		}
+	}
+	tmc.unlock(s)
+}
+
+// forget deletes the in-flight load or cached error i from s if it is still
+// the item of key. The caller holds s.mu. Neither has a value to report to
+// OnRemove.
+func (tmc *TMCache[K, V]) forget(s *shard[K, V], key K, i *item[V], reason RemovalReason) {
+	if s.items[key] != i {
+		return
+	}
+	delete(s.items, key)
+	s.expiry.unschedule(key)
+	if reason == Expired {
+		tmc.stats.expirations.Add(1)
+	}
+	if !i.loading {
+		tmc.untrack(key)
+	}
+}
+
+// remove deletes the stored record r of key from s. The caller holds s.mu.
+func (tmc *TMCache[K, V]) remove(s *shard[K, V], key K, r Record[V], reason RemovalReason) {
+	s.store.Delete(key)
+	s.expiry.unschedule(key)
+	tmc.removed(s, key, r.Value, reason)
+	if reason == Expired {
+		tmc.stats.expirations.Add(1)
+	}
+	tmc.untrack(key)
+}
+
+// untrack makes the policy forget key, which left the cache for a reason
+// other than eviction.
+func (tmc *TMCache[K, V]) untrack(key K) {
+	if tmc.policy == nil {
+		return
+	}
+	tmc.policyMu.Lock()
+	if cost, ok := tmc.tracked[key]; ok {
+		delete(tmc.tracked, key)
+		tmc.totalCost -= cost
+		tmc.policy.Remove(key)
 	}
-	return i.res.value, chit, i.res.err
+	tmc.policyMu.Unlock()
 }
 
-func (tmc *TMCache) Del(key string) {
-	tmc.mu.Lock()
-	delete(tmc.items, key)
-	tmc.mu.Unlock()
+// removed queues the OnRemove callback for value. The caller holds s.mu; the
+// callback runs in unlock.
+func (tmc *TMCache[K, V]) removed(s *shard[K, V], key K, value V, reason RemovalReason) {
+	if tmc.onRemove != nil {
+		s.removed = append(s.removed, removal[K, V]{key: key, value: value, reason: reason})
+	}
+}
+
+// deferred is the work queued while shard locks were held: values to write
+// to disk and OnRemove callbacks.
+type deferred[K comparable, V any] struct {
+	removed []removal[K, V]
+	spills  []spillWrite[K, V]
+}
+
+// unlock releases s.mu, then writes the values queued for disk and runs the
+// OnRemove callbacks queued while it was held, so they may call back into
+// the cache.
+func (tmc *TMCache[K, V]) unlock(s *shard[K, V]) {
+	var d deferred[K, V]
+	tmc.release(s, &d)
+	tmc.run(d)
+}
+
+// release moves the work queued in s to d and releases s.mu.
+func (tmc *TMCache[K, V]) release(s *shard[K, V], d *deferred[K, V]) {
+	d.removed = append(d.removed, s.removed...)
+	d.spills = append(d.spills, s.spills...)
+	s.removed, s.spills = nil, nil
+	s.mu.Unlock()
 }
 
-func (tmc *TMCache) EraseAll() {
-	for k := range tmc.items {
-		delete(tmc.items, k)
+// run does the work in d. It is called with no lock held.
+func (tmc *TMCache[K, V]) run(d deferred[K, V]) {
+	for _, w := range d.spills {
+		tmc.flush(w)
+	}
+	if tmc.disk != nil {
+		tmc.disk.empty()
+	}
+	for _, r := range d.removed {
+		tmc.onRemove(r.key, r.value, r.reason)
 	}
 }
 
-func (tmc *TMCache) Close() {
-	tmc.mu.Lock()
+// admit hands a stored key or cached error of the given cost to the eviction
+// policy and returns the keys to evict to bring the cache back within its
+// bounds. The caller holds the lock of key's shard; the victims may live in
+// any shard, so they are removed by evict once that lock is released.
+func (tmc *TMCache[K, V]) admit(key K, cost int64) []K {
+	if tmc.policy == nil {
+		return nil
+	}
+	tmc.policyMu.Lock()
+	defer tmc.policyMu.Unlock()
 
+	var evicted []K
+	drop := func(k K) {
+		if c, ok := tmc.tracked[k]; ok {
+			delete(tmc.tracked, k)
+			tmc.totalCost -= c
+			evicted = append(evicted, k)
+		}
+	}
+
+	// The policy picks victims by the hits it knows of.
+	tmc.drain()
+	tmc.tracked[key] = cost
+	tmc.totalCost += cost
+	for _, k := range tmc.policy.Add(key) {
+		drop(k)
+	}
+	for tmc.maxCost > 0 && tmc.totalCost > tmc.maxCost {
+		k, ok := tmc.policy.Victim()
+		if !ok {
+			break
+		}
+		drop(k)
+	}
+	return evicted
+}
+
+// tracking reports whether key was handed to the policy again since it was
+// dropped.
+func (tmc *TMCache[K, V]) tracking(key K) bool {
+	tmc.policyMu.Lock()
+	defer tmc.policyMu.Unlock()
+	_, ok := tmc.tracked[key]
+	return ok
+}
+
+// cache stores the successfully loaded outcome o of key in s, or queues it
+// for disk only if it is large, and returns the keys to evict for it. The
+// caller holds s.mu.
+func (tmc *TMCache[K, V]) cache(s *shard[K, V], key K, o outcome[V]) []K {
+	if o.spill != nil {
+		f := tmc.disk.reserve(key, o.spillFile(true))
+		s.spills = append(s.spills, spillWrite[K, V]{key: key, file: f, data: o.spill})
+		return nil
+	}
+	if tmc.disk != nil {
+		tmc.disk.delete(key)
+	}
+	s.store.Set(key, o.record())
+	s.expiry.schedule(key, o.expires)
+	return tmc.admit(key, o.cost)
+}
+
+// evict deletes the keys the policy has dropped, unless they were cached
+// again in the meantime. With WithDiskSpill unexpired values move to disk
+// instead; they are reported as evicted all the same, as the value in
+// memory is dropped. The disk writes and callbacks are queued in d.
+func (tmc *TMCache[K, V]) evict(evicted []K, d *deferred[K, V]) {
+	for _, key := range evicted {
+		s := tmc.shard(key)
+		s.mu.Lock()
+		if i := s.items[key]; i != nil && !i.loading && !tmc.tracking(key) {
+			tmc.forget(s, key, i, Evicted)
+			tmc.stats.evictions.Add(1)
+		} else if r, ok := s.store.Get(key); ok && !tmc.tracking(key) {
+			s.store.Delete(key)
+			s.expiry.unschedule(key)
+			tmc.stats.evictions.Add(1)
+			if tmc.disk != nil && r.Expires.After(time.Now()) {
+				o := r.outcome()
+				f := tmc.disk.reserve(key, o.spillFile(false))
+				s.spills = append(s.spills, spillWrite[K, V]{key: key, file: f, o: o})
+			}
+			tmc.removed(s, key, r.Value, Evicted)
+		}
+		tmc.release(s, d)
+	}
+}
+
+// Set caches value for key without calling the loader. If key is being
+// loaded, Set wins: the callers waiting on the load get value, and the
+// loader's result is dropped.
+func (tmc *TMCache[K, V]) Set(key K, value V, ttl time.Duration) {
+	o, keep := tmc.settle(ttl, value, EntryOptions{}, nil)
+	tmc.put(key, o, keep)
+}
+
+// put caches o for key as Set does, dropping it unless keep.
+func (tmc *TMCache[K, V]) put(key K, o outcome[V], keep bool) {
+	s := tmc.shard(key)
+	s.mu.Lock()
+	if i := s.items[key]; i != nil {
+		if i.loading {
+			tmc.complete(s, key, i, o, keep)
+			return
+		}
+		tmc.forget(s, key, i, Replaced)
+	}
+	if r, ok := s.store.Get(key); ok {
+		tmc.remove(s, key, r, Replaced)
+	}
+	if tmc.disk != nil {
+		tmc.disk.delete(key)
+	}
+
+	var evicted []K
+	if keep {
+		evicted = tmc.cache(s, key, o)
+	}
+	var d deferred[K, V]
+	tmc.release(s, &d)
+	tmc.evict(evicted, &d)
+	tmc.run(d)
+}
+
+// Peek returns the cached value of key without loading it or counting as a
+// use. It reports false if key is not cached, still loading, expired or
+// cached as an error. A value past its TTL counts as expired even during the
+// WithStaleWhileRevalidate grace period.
+func (tmc *TMCache[K, V]) Peek(key K) (V, bool) {
+	s := tmc.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.items[key] == nil {
+		if r, ok := s.store.Get(key); ok && r.Deadline.After(time.Now()) {
+			return r.Value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Has reports whether Peek would find key.
+func (tmc *TMCache[K, V]) Has(key K) bool {
+	_, ok := tmc.Peek(key)
+	return ok
+}
+
+func (tmc *TMCache[K, V]) Del(key K) {
+	s := tmc.shard(key)
+	s.mu.Lock()
+	if i := s.items[key]; i != nil {
+		tmc.forget(s, key, i, Deleted)
+	}
+	if r, ok := s.store.Get(key); ok {
+		tmc.remove(s, key, r, Deleted)
+	}
+	if tmc.disk != nil {
+		tmc.disk.delete(key)
+	}
+	tmc.unlock(s)
+}
+
+// DelTag deletes every entry whose EntryOptions had tag among its Tags.
+func (tmc *TMCache[K, V]) DelTag(tag string) {
+	for _, s := range tmc.shards {
+		s.mu.Lock()
+		for k, i := range s.items {
+			if hasTag(i.tags, tag) {
+				tmc.forget(s, k, i, Deleted)
+			}
+		}
+		for _, e := range s.records() {
+			if hasTag(e.record.Tags, tag) {
+				tmc.remove(s, e.key, e.record, Deleted)
+			}
+		}
+		tmc.unlock(s)
+	}
+	if tmc.disk != nil {
+		tmc.disk.deleteTag(tag)
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (tmc *TMCache[K, V]) EraseAll() {
+	for _, s := range tmc.shards {
+		s.mu.Lock()
+		tmc.eraseAll(s, Deleted)
+		tmc.unlock(s)
+	}
+	if tmc.disk != nil {
+		tmc.disk.clear()
+	}
+}
+
+func (tmc *TMCache[K, V]) eraseAll(s *shard[K, V], reason RemovalReason) {
+	for k, i := range s.items {
+		tmc.forget(s, k, i, reason)
+	}
+	for _, e := range s.records() {
+		tmc.remove(s, e.key, e.record, reason)
+	}
+}
+
+// Close stops the cleanup, deletes every entry and closes the stores. Spill
+// files being written are deleted before it returns. The cache must not be
+// used afterwards.
+func (tmc *TMCache[K, V]) Close() {
 	close(tmc.done)
-	tmc.EraseAll()
-	tmc.items = nil
 
-	tmc.mu.Unlock()
+	for _, s := range tmc.shards {
+		s.mu.Lock()
+		tmc.eraseAll(s, Closed)
+		s.store.Close()
+		s.items = nil
+		tmc.unlock(s)
+	}
+	if tmc.disk != nil {
+		tmc.disk.clear()
+		tmc.disk.wait()
+	}
 }
 
 func HttpGetBody(url string) (interface{}, error) {
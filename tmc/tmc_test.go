@@ -1,6 +1,9 @@
 package tmc
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -50,3 +53,245 @@ func TestKeyCleanedAfterTTL(t *testing.T) {
 	}
 
 }
+
+type user struct {
+	id   int
+	name string
+}
+
+func TestTypedKeysAndValues(t *testing.T) {
+	cache := New(func(id int) (user, error) {
+		return user{id: id, name: "user"}, nil
+	}, 10*second)
+	defer cache.Close()
+
+	cache.Get(7, 10*second)
+	val, chit, err := cache.Get(7, 10*second)
+	if err != nil {
+		t.Error("Error: cache.Get(7, 10seconds)")
+	} else if chit != true {
+		t.Error("Error: cache hit = false; expected true")
+	} else if val.id != 7 || val.name != "user" {
+		t.Errorf("Error: val = %+v; expected {id:7 name:user}", val)
+	}
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	cancelled := make(chan struct{})
+	cache := NewContext(func(ctx context.Context, key string) (string, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	}, 10*second)
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err := cache.GetContext(ctx, "key", 10*second)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Error: err = %v; expected context.DeadlineExceeded", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(second):
+		t.Error("Error: loader context was not cancelled after the last waiter left")
+	}
+}
+
+func TestLoaderPanicIsRecovered(t *testing.T) {
+	calls := 0
+	cache := New(func(key string) (string, error) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		return "value", nil
+	}, 10*second)
+	defer cache.Close()
+
+	_, _, err := cache.Get("key", 10*second)
+	if perr, ok := err.(*PanicError); !ok {
+		t.Errorf("Error: err = %v; expected *PanicError", err)
+	} else if perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Errorf("Error: PanicError = {%v, %d byte stack}; expected {boom, stack}", perr.Value, len(perr.Stack))
+	} else if msg := perr.Error(); msg != "tmc: loader panicked: boom" {
+		t.Errorf("Error: Error() = %q; expected %q", msg, "tmc: loader panicked: boom")
+	}
+
+	val, chit, err := cache.Get("key", 10*second)
+	if err != nil {
+		t.Errorf("Error: err = %v on retry; expected nil", err)
+	} else if chit != false {
+		t.Error("Error: cache hit = true after panic; expected false")
+	} else if val != "value" {
+		t.Error("Error: expected val = 'value'")
+	}
+}
+
+func TestKeyExpiresBeforeCleanup(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return "value", nil
+	}, hour)
+	defer cache.Close()
+
+	cache.Get("key", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	_, chit, err := cache.Get("key", 10*time.Millisecond)
+	if err != nil {
+		t.Error("Error: cache.Get('key', 10ms)")
+	} else if chit != false {
+		t.Error("Error: cache hit = true past ttl; expected false")
+	}
+}
+
+func TestConcurrentGetsLoadOncePerKey(t *testing.T) {
+	var calls int64
+	cache := New(func(key int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return key * 2, nil
+	}, hour)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for n := 0; n < 1000; n++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			if val, _, _ := cache.Get(key, hour); val != key*2 {
+				t.Errorf("Error: val = %d; expected %d", val, key*2)
+			}
+		}(n % 100)
+	}
+	wg.Wait()
+
+	if calls != 100 {
+		t.Errorf("Error: loader called %d times; expected 100", calls)
+	}
+}
+
+func TestSetPeekHas(t *testing.T) {
+	calls := 0
+	cache := New(func(key string) (string, error) {
+		calls++
+		return "loaded", nil
+	}, hour)
+	defer cache.Close()
+
+	if _, ok := cache.Peek("key"); ok || cache.Has("key") {
+		t.Error("Error: Peek found a key that was never cached")
+	}
+	cache.Set("key", "set", hour)
+	if val, ok := cache.Peek("key"); !ok || val != "set" {
+		t.Errorf("Error: Peek = %q, %t; expected 'set', true", val, ok)
+	}
+	val, chit, _ := cache.Get("key", hour)
+	if val != "set" || !chit || calls != 0 {
+		t.Errorf("Error: Get = %q, %t after %d loads; expected 'set', true, 0 loads", val, chit, calls)
+	}
+}
+
+func TestPeekStale(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithStaleWhileRevalidate[string, string](hour))
+	defer cache.Close()
+
+	cache.Set("key", "set", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := cache.Peek("key"); ok || cache.Has("key") {
+		t.Error("Error: Peek found a value past its TTL")
+	}
+}
+
+func TestSetWinsOverInFlightLoad(t *testing.T) {
+	release := make(chan struct{})
+	loaded := make(chan struct{})
+	cache := New(func(key string) (string, error) {
+		<-release
+		defer close(loaded)
+		return "loaded", nil
+	}, hour)
+	defer cache.Close()
+
+	got := make(chan string)
+	go func() {
+		val, _, _ := cache.Get("key", hour)
+		got <- val
+	}()
+	for cache.Stats().Misses == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.Set("key", "set", hour)
+	if val := <-got; val != "set" {
+		t.Errorf("Error: waiter got %q; expected 'set'", val)
+	}
+
+	close(release)
+	<-loaded
+	time.Sleep(time.Millisecond)
+	if val, _ := cache.Peek("key"); val != "set" {
+		t.Errorf("Error: Peek = %q after the load finished; expected 'set'", val)
+	}
+}
+
+func TestEntryLoaderOptions(t *testing.T) {
+	cache := NewEntry(func(ctx context.Context, key string) (string, EntryOptions, error) {
+		switch key {
+		case "short":
+			return key, EntryOptions{TTL: 10 * time.Millisecond}, nil
+		case "uncached":
+			return key, EntryOptions{NoCache: true}, nil
+		}
+		return key, EntryOptions{Tags: []string{"tagged"}}, nil
+	}, hour)
+	defer cache.Close()
+
+	for _, key := range []string{"short", "uncached", "a", "b"} {
+		cache.Get(key, hour)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if cache.Has("short") {
+		t.Error("Error: entry outlived the TTL set by its loader")
+	}
+	if cache.Has("uncached") {
+		t.Error("Error: entry loaded with NoCache was cached")
+	}
+	if !cache.Has("a") || !cache.Has("b") {
+		t.Error("Error: tagged entries missing before DelTag")
+	}
+	cache.DelTag("tagged")
+	if cache.Has("a") || cache.Has("b") {
+		t.Error("Error: tagged entries present after DelTag")
+	}
+}
+
+func TestCleanupVisitsOnlyDueEntries(t *testing.T) {
+	cache := New(func(key string) (string, error) {
+		return key, nil
+	}, hour, WithShards[string, string](1))
+	defer cache.Close()
+
+	cache.Set("due", "value", 10*time.Millisecond)
+	cache.Set("later", "value", hour)
+	cache.Set("deleted", "value", 10*time.Millisecond)
+	cache.Del("deleted")
+	cache.Set("replaced", "value", 10*time.Millisecond)
+	cache.Set("replaced", "value", hour)
+
+	s := cache.shards[0]
+	if s.expiry.Len() != 3 {
+		t.Errorf("Error: %d entries queued for expiry; expected 3", s.expiry.Len())
+	}
+	time.Sleep(20 * time.Millisecond)
+	cache.routineCleanup()
+	if n := cache.Stats().Expirations; n != 1 {
+		t.Errorf("Error: cleanup expired %d entries; expected 1", n)
+	}
+	if s.expiry.Len() != 2 || s.store.Len() != 2 {
+		t.Errorf("Error: %d entries queued, %d cached after cleanup; expected 2", s.expiry.Len(), s.store.Len())
+	}
+}
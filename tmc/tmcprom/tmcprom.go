@@ -0,0 +1,121 @@
+// Package tmcprom serves the statistics of tmc caches in the Prometheus text
+// exposition format, without depending on a Prometheus client library.
+package tmcprom
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sanketitnal/gotmc/tmc"
+)
+
+// Source is implemented by every *tmc.TMCache.
+type Source interface {
+	Stats() tmc.Stats
+}
+
+// Exporter is an http.Handler that serves the metrics of its registered
+// caches, each labelled with cache="<name>".
+type Exporter struct {
+	mu     sync.Mutex
+	caches map[string]Source
+}
+
+// New returns an Exporter with no caches registered.
+func New() *Exporter {
+	return &Exporter{caches: make(map[string]Source)}
+}
+
+// Register exports c under name, replacing any cache registered under it.
+func (e *Exporter) Register(name string, c Source) {
+	e.mu.Lock()
+	e.caches[name] = c
+	e.mu.Unlock()
+}
+
+func (e *Exporter) Unregister(name string) {
+	e.mu.Lock()
+	delete(e.caches, name)
+	e.mu.Unlock()
+}
+
+type snapshot struct {
+	name  string
+	stats tmc.Stats
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	snaps := make([]snapshot, 0, len(e.caches))
+	for name, c := range e.caches {
+		snaps = append(snaps, snapshot{name: name, stats: c.Stats()})
+	}
+	e.mu.Unlock()
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].name < snaps[j].name })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	counter := func(name, help string, value func(tmc.Stats) int64) {
+		header(bw, name, "counter", help)
+		for _, s := range snaps {
+			fmt.Fprintf(bw, "%s{cache=%s} %d\n", name, quote(s.name), value(s.stats))
+		}
+	}
+	counter("tmc_hits_total", "Reads answered from the cache.", func(s tmc.Stats) int64 { return s.Hits })
+	counter("tmc_misses_total", "Reads that started a load.", func(s tmc.Stats) int64 { return s.Misses })
+	counter("tmc_coalesced_total", "Reads that waited on a load already in flight.", func(s tmc.Stats) int64 { return s.Coalesced })
+	counter("tmc_evictions_total", "Entries evicted by the eviction policy.", func(s tmc.Stats) int64 { return s.Evictions })
+	counter("tmc_expirations_total", "Entries removed after their TTL.", func(s tmc.Stats) int64 { return s.Expirations })
+
+	header(bw, "tmc_loads_total", "counter", "Loader calls by result.")
+	for _, s := range snaps {
+		fmt.Fprintf(bw, "tmc_loads_total{cache=%s,result=\"success\"} %d\n", quote(s.name), s.stats.LoadSuccesses)
+		fmt.Fprintf(bw, "tmc_loads_total{cache=%s,result=\"failure\"} %d\n", quote(s.name), s.stats.LoadFailures)
+	}
+
+	header(bw, "tmc_entries", "gauge", "Entries in the cache, loads in flight included.")
+	for _, s := range snaps {
+		fmt.Fprintf(bw, "tmc_entries{cache=%s} %d\n", quote(s.name), s.stats.Entries)
+	}
+
+	header(bw, "tmc_load_duration_seconds", "histogram", "Loader latency.")
+	for _, s := range snaps {
+		var count int64
+		for n, le := range tmc.LoadTimeBuckets {
+			count += bucket(s.stats, n)
+			fmt.Fprintf(bw, "tmc_load_duration_seconds_bucket{cache=%s,le=\"%s\"} %d\n",
+				quote(s.name), strconv.FormatFloat(le.Seconds(), 'g', -1, 64), count)
+		}
+		count += bucket(s.stats, len(tmc.LoadTimeBuckets))
+		fmt.Fprintf(bw, "tmc_load_duration_seconds_bucket{cache=%s,le=\"+Inf\"} %d\n", quote(s.name), count)
+		fmt.Fprintf(bw, "tmc_load_duration_seconds_sum{cache=%s} %s\n",
+			quote(s.name), strconv.FormatFloat(s.stats.TotalLoadTime.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "tmc_load_duration_seconds_count{cache=%s} %d\n", quote(s.name), count)
+	}
+}
+
+// bucket returns LoadTimes[n] of st, or 0 if a Source left it out.
+func bucket(st tmc.Stats, n int) int64 {
+	if n < len(st.LoadTimes) {
+		return st.LoadTimes[n]
+	}
+	return 0
+}
+
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns v as a quoted label value.
+func quote(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
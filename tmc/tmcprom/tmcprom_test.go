@@ -0,0 +1,89 @@
+package tmcprom
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanketitnal/gotmc/tmc"
+)
+
+type fixed tmc.Stats
+
+func (f fixed) Stats() tmc.Stats {
+	return tmc.Stats(f)
+}
+
+func TestExporter(t *testing.T) {
+	loadTimes := make([]int64, len(tmc.LoadTimeBuckets)+1)
+	loadTimes[0] = 2
+	loadTimes[len(loadTimes)-1] = 1
+
+	e := New()
+	e.Register(`a"b`, fixed{
+		Hits:          5,
+		Misses:        3,
+		LoadSuccesses: 2,
+		LoadFailures:  1,
+		TotalLoadTime: 1500 * time.Millisecond,
+		LoadTimes:     loadTimes,
+		Entries:       2,
+	})
+	cache := tmc.New(func(key string) (string, error) {
+		return key, nil
+	}, time.Hour)
+	defer cache.Close()
+	cache.Get("key", time.Hour)
+	e.Register("live", cache)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Error: Content-Type = %q; expected text/plain; version=0.0.4", ct)
+	}
+	for _, line := range []string{
+		"# TYPE tmc_hits_total counter",
+		`tmc_hits_total{cache="a\"b"} 5`,
+		`tmc_misses_total{cache="live"} 1`,
+		`tmc_loads_total{cache="a\"b",result="failure"} 1`,
+		"# TYPE tmc_entries gauge",
+		`tmc_entries{cache="live"} 1`,
+		"# TYPE tmc_load_duration_seconds histogram",
+		`tmc_load_duration_seconds_bucket{cache="a\"b",le="0.001"} 2`,
+		`tmc_load_duration_seconds_bucket{cache="a\"b",le="10"} 2`,
+		`tmc_load_duration_seconds_bucket{cache="a\"b",le="+Inf"} 3`,
+		`tmc_load_duration_seconds_sum{cache="a\"b"} 1.5`,
+		`tmc_load_duration_seconds_count{cache="a\"b"} 3`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Error: missing line %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestExporterWithoutLoadTimes(t *testing.T) {
+	e := New()
+	e.Register("bare", fixed{Hits: 1})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`tmc_hits_total{cache="bare"} 1`,
+		`tmc_load_duration_seconds_bucket{cache="bare",le="+Inf"} 0`,
+		`tmc_load_duration_seconds_count{cache="bare"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Error: missing line %q in\n%s", line, body)
+		}
+	}
+}